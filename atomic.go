@@ -0,0 +1,70 @@
+package cors
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AtomicConfig holds a CORS configuration that can be replaced at runtime
+// while requests are being served by its Handler.
+type AtomicConfig struct {
+	cors atomic.Pointer[cors]
+	now  func() time.Time
+}
+
+// NewAtomicConfig returns an AtomicConfig initialized with config.
+// It panics if config is invalid, like New.
+func NewAtomicConfig(config Config) *AtomicConfig {
+	a := &AtomicConfig{now: time.Now}
+	a.Set(config)
+	return a
+}
+
+// Set replaces the current configuration. It panics if config is invalid,
+// so callers reloading configuration should call Validate first.
+func (a *AtomicConfig) Set(config Config) {
+	a.cors.Store(newCors(config))
+}
+
+// SetWithGrace replaces the current configuration like Set, but keeps
+// graceOrigins allowed for the duration d even if newCfg no longer allows
+// them. Grace origins are matched like AllowOrigins of newCfg. Once d has
+// elapsed only newCfg is used.
+func (a *AtomicConfig) SetWithGrace(newCfg Config, graceOrigins []string, d time.Duration) {
+	if len(graceOrigins) == 0 || d <= 0 || newCfg.AllowAllOrigins {
+		a.Set(newCfg)
+		return
+	}
+
+	grace := newCfg.normalizeOrigins(append([]string(nil), graceOrigins...))
+	exactMatchOnly := newCfg.ExactMatchOnly
+	until := a.now().Add(d)
+	allowOriginFunc := newCfg.AllowOriginFunc
+	newCfg.AllowOriginFunc = func(origin string) bool {
+		if allowOriginFunc != nil && allowOriginFunc(origin) {
+			return true
+		}
+		if a.now().After(until) {
+			return false
+		}
+		if !exactMatchOnly {
+			origin = normalizeIPv6Host(stripDefaultPort(origin))
+		}
+		for _, value := range grace {
+			if value == origin {
+				return true
+			}
+		}
+		return false
+	}
+	a.Set(newCfg)
+}
+
+// Handler returns a middleware that applies the current configuration.
+func (a *AtomicConfig) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.cors.Load().applyCors(c)
+	}
+}
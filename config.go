@@ -1,22 +1,132 @@
 package cors
 
 import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 )
 
 type cors struct {
 	allowAllOrigins            bool
+	allowOriginStar            bool
 	allowCredentials           bool
 	allowOriginFunc            func(string) bool
 	allowOriginWithContextFunc func(*gin.Context, string) bool
+	allowOriginTokenFunc       func(*gin.Context) (string, bool)
+	allowOriginByClientCert    func(string, *x509.Certificate) bool
+	resolvedIPs                *ipResolver
 	allowOrigins               []string
 	normalHeaders              http.Header
-	preflightHeaders           http.Header
+	preflightHeaders           []headerEntry
 	wildcardOrigins            [][]string
+	suffixRules                *suffixTrie
 	optionsResponseStatusCode  int
+	preflightBody              []byte
+	allowMethods               []string
+	enforceMethod              bool
+	exposeHeadersFunc          func(string) []string
+	regexpOrigins              []*regexp.Regexp
+	portRangeOrigins           []portRangeOrigin
+	allowLocalhost             bool
+	reflectRequestHeaders      bool
+	wildcardMatchesApex        bool
+	wildcardApexes             []string
+	denyBody                   []byte
+	passNonPreflightOptions    bool
+	treatEmptyOriginAsCORS     bool
+	listSeparator              string
+	fallbackToHostOrigin       bool
+	noPreflightMethods         []string
+	strictMode                 bool
+	allowPrivateNetwork        bool
+	originGlobs                []string
+	maxAgeByOrigin             map[string]string
+	idempotentMaxAge           string
+	wwwStrippedOrigins         []string
+	reportFunc                 func(string, string)
+	reportOnly                 bool
+	secFetchSites              []string
+	allowMissingSecFetchSite   bool
+	preserveHeaderCase         bool
+	allowSameOrigin            bool
+	newOrigins                 *originSet
+	originFuncPanicStatusCode  int
+	onOriginFuncPanic          func(*gin.Context, error)
+	afterApply                 func(*gin.Context, bool)
+	maxRequestHeaders          int
+	maxAllowHeadersBytes       int
+	echoMethodIntersection     bool
+	allowAnyMethod             bool
+	originsByMethod            map[string][]string
+	debugMode                  bool
+	logger                     Logger
+	canonicalizeOrigin         bool
+	allowOriginValueFunc       func(string) string
+	exactMatchOnly             bool
+	allowCredentialsFunc       func(string) bool
+	originStore                OriginStore
+	requireHTTPS               bool
+	requireSchemeMatch         bool
+	serverHostnames            []string
+	requireSNI                 bool
+	manualPreflight            bool
+	credentialsOnlyWhenPresent bool
+	omitUnrequestedHeaders     bool
+	credentialsOnActualOnly    bool
+	deniedStatusFunc           func(*gin.Context, string, string) int
+	minimalHeaders             bool
+	silentPreflightDeny        bool
+	strictMethodCase           bool
+	allowAnyHeader             bool
+	allowExtraHeaders          bool
+	echoHeaderIntersection     bool
+	allowHeadersFunc           func(*gin.Context, string) []string
+	allowHeaders               []string
+}
+
+// maxSeenOrigins bounds the number of origins remembered for LogNewOrigins.
+const maxSeenOrigins = 10000
+
+// originSet calls fn once for every origin it has not seen yet.
+type originSet struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+	max  int
+	fn   func(string)
+}
+
+func newOriginSet(fn func(string)) *originSet {
+	if fn == nil {
+		return nil
+	}
+	return &originSet{
+		seen: make(map[string]struct{}),
+		max:  maxSeenOrigins,
+		fn:   fn,
+	}
+}
+
+func (s *originSet) observe(origin string) {
+	s.mu.Lock()
+	if _, ok := s.seen[origin]; ok {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.seen) >= s.max {
+		s.seen = make(map[string]struct{})
+	}
+	s.seen[origin] = struct{}{}
+	s.mu.Unlock()
+	s.fn(origin)
 }
 
 var (
@@ -37,76 +147,618 @@ var (
 		"ws://",
 		"wss://",
 	}
+	SimpleHeaders = []string{
+		"Accept",
+		"Accept-Language",
+		"Content-Language",
+		"Content-Type",
+	}
 )
 
 func newCors(config Config) *cors {
-	if err := config.Validate(); err != nil {
-		panic(err.Error())
-	}
-
-	for _, origin := range config.AllowOrigins {
-		if origin == "*" {
-			config.AllowAllOrigins = true
-		}
+	cors, err := compileCors(config)
+	if err != nil {
+		panic("cors: " + err.Error())
 	}
+	return cors
+}
 
-	if config.OptionsResponseStatusCode == 0 {
-		config.OptionsResponseStatusCode = http.StatusNoContent
+// compileCors is like newCors but returns an error for an invalid config.
+func compileCors(config Config) (*cors, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
+	config = config.withCompileDefaults()
 
-	return &cors{
-		allowOriginFunc:            config.AllowOriginFunc,
+	cors := &cors{
+		allowOriginFunc:            anyOriginFunc(config.AllowOriginFunc, config.AllowOriginFuncs),
 		allowOriginWithContextFunc: config.AllowOriginWithContextFunc,
+		allowOriginTokenFunc:       config.AllowOriginTokenFunc,
+		allowOriginByClientCert:    config.AllowOriginByClientCert,
 		allowAllOrigins:            config.AllowAllOrigins,
+		allowOriginStar:            config.allowOriginStar(),
 		allowCredentials:           config.AllowCredentials,
-		allowOrigins:               normalize(config.AllowOrigins),
+		allowOrigins:               config.exactOrigins(),
 		normalHeaders:              generateNormalHeaders(config),
-		preflightHeaders:           generatePreflightHeaders(config),
+		preflightHeaders:           flattenHeaders(generatePreflightHeaders(config)),
 		wildcardOrigins:            config.parseWildcardRules(),
 		optionsResponseStatusCode:  config.OptionsResponseStatusCode,
+		preflightBody:              config.preflightBodyBytes(),
+		allowMethods:               convert(normalize(config.AllowMethods), strings.ToUpper),
+		enforceMethod:              config.EnforceMethodOnActualRequest && config.AllowCredentials,
+		exposeHeadersFunc:          config.ExposeHeadersFunc,
+		regexpOrigins:              compileRegexpOrigins(config.AllowOrigins),
+		portRangeOrigins:           parsePortRangeOrigins(config.AllowOrigins),
+		allowLocalhost:             config.AllowLocalhost,
+		reflectRequestHeaders:      config.SkipRequestHeaderValidation,
+		wildcardMatchesApex:        config.WildcardMatchesApex,
+		wildcardApexes:             config.WildcardApexes,
+		denyBody:                   config.denyBodyBytes(),
+		passNonPreflightOptions:    config.PassNonPreflightOptions,
+		treatEmptyOriginAsCORS:     config.TreatEmptyOriginAsCORS,
+		listSeparator:              config.listSeparator(),
+		fallbackToHostOrigin:       config.FallbackToHostOrigin,
+		noPreflightMethods:         convert(normalize(config.NoPreflightMethods), strings.ToUpper),
+		strictMode:                 config.StrictMode,
+		allowPrivateNetwork:        config.AllowPrivateNetwork,
+		originGlobs:                normalize(config.AllowOriginGlobs),
+		maxAgeByOrigin:             generateMaxAgeByOrigin(config),
+		idempotentMaxAge:           formatMaxAge(config.IdempotentMaxAge),
+		reportFunc:                 config.ReportFunc,
+		reportOnly:                 config.ReportOnly,
+		secFetchSites:              normalize(config.RequireSecFetchSite),
+		allowMissingSecFetchSite:   config.AllowMissingSecFetchSite,
+		preserveHeaderCase:         config.PreserveHeaderCase,
+		allowSameOrigin:            config.AllowSameOrigin,
+		onOriginFuncPanic:          config.OnOriginFuncPanic,
+		afterApply:                 config.AfterApply,
+		maxRequestHeaders:          config.MaxRequestHeaders,
+		maxAllowHeadersBytes:       config.MaxAllowHeadersBytes,
+		echoMethodIntersection:     config.EchoMethodIntersection,
+		debugMode:                  config.DebugMode,
+		canonicalizeOrigin:         config.CanonicalizeReflectedOrigin,
+		allowOriginValueFunc:       config.AllowOriginValueFunc,
+		exactMatchOnly:             config.ExactMatchOnly,
+		allowCredentialsFunc:       config.AllowCredentialsFunc,
+		originStore:                config.OriginStore,
+		requireHTTPS:               config.RequireHTTPS,
+		requireSchemeMatch:         config.RequireSchemeMatch,
+		requireSNI:                 config.RequireOriginMatchesSNI,
+		manualPreflight:            config.ManualPreflight,
+		credentialsOnlyWhenPresent: config.CredentialsOnlyWhenPresent,
+		omitUnrequestedHeaders:     config.OmitAllowHeadersWhenNotRequested,
+		credentialsOnActualOnly:    config.CredentialsOnActualOnly,
+		deniedStatusFunc:           config.DeniedStatusFunc,
+		minimalHeaders:             config.MinimalHeaders,
+		silentPreflightDeny:        config.SilentPreflightDeny,
+		strictMethodCase:           config.StrictMethodCase,
+		allowExtraHeaders:          config.AllowExtraRequestedHeaders,
+		echoHeaderIntersection:     config.EchoHeaderIntersection,
+		allowHeadersFunc:           config.AllowHeadersFunc,
+		originFuncPanicStatusCode:  config.OriginFuncPanicStatusCode,
+		allowAnyMethod:             config.EmptyMethodsAllowAll && len(config.AllowMethods) == 0,
+		logger:                     config.Logger,
+		serverHostnames:            config.deniedHostnames(),
+		resolvedIPs:                config.ipResolver(),
+		allowHeaders:               headerNames(config.allowHeaderList(), config.PreserveHeaderCase),
+		allowAnyHeader:             containsAnyHeader(normalize(config.AllowHeaders)),
+		originsByMethod:            config.compileOriginsByMethod(),
+		newOrigins:                 newOriginSet(config.LogNewOrigins),
+	}
+	cors.indexOrigins(config.IgnoreWWWPrefix)
+
+	if config.OnCompile != nil {
+		config.OnCompile(cors.summary(config))
 	}
+
+	return cors, nil
 }
 
-func (cors *cors) applyCors(c *gin.Context) {
+// withCompileDefaults returns c with the defaults of its unset fields and
+// AllowAllOrigins set when AllowOrigins is "*".
+func (c Config) withCompileDefaults() Config {
+	c = c.withOriginsCSV()
+	for _, origin := range c.AllowOrigins {
+		if origin == "*" {
+			c.AllowAllOrigins = true
+		}
+	}
+	if c.OptionsResponseStatusCode == 0 {
+		c.OptionsResponseStatusCode = http.StatusNoContent
+		if c.PreflightBody != "" {
+			c.OptionsResponseStatusCode = http.StatusOK
+		}
+	}
+	if c.OriginFuncPanicStatusCode == 0 {
+		c.OriginFuncPanicStatusCode = http.StatusForbidden
+	}
+	if c.Logger == nil {
+		c.Logger = log.Default()
+	}
+	return c
+}
+
+// exactOrigins returns the origins of AllowOrigins, AllowOriginURLs and
+// AllowChromeExtensionIDs, normalized unless ExactMatchOnly is set.
+func (c Config) exactOrigins() []string {
+	origins := make([]string, 0, len(c.AllowOrigins)+len(c.AllowOriginURLs)+len(c.AllowChromeExtensionIDs))
+	for _, origin := range c.AllowOrigins {
+		// port ranges are matched separately, never as exact origins
+		if _, ok, _ := parsePortRangeOrigin(origin); !ok {
+			origins = append(origins, origin)
+		}
+	}
+	for _, u := range c.AllowOriginURLs {
+		origins = append(origins, originFromURL(u))
+	}
+	for _, id := range c.AllowChromeExtensionIDs {
+		origins = append(origins, "chrome-extension://"+strings.TrimSpace(id))
+	}
+	return c.normalizeOrigins(origins)
+}
+
+// normalizeOrigins normalizes origins as compared with the request origin,
+// or returns them unchanged when ExactMatchOnly is set.
+func (c Config) normalizeOrigins(origins []string) []string {
+	if c.ExactMatchOnly {
+		return origins
+	}
+	return convert(convert(normalize(origins), stripDefaultPort), normalizeIPv6Host)
+}
+
+func (c Config) preflightBodyBytes() []byte {
+	if c.PreflightBody == "" {
+		return nil
+	}
+	return []byte(c.PreflightBody)
+}
+
+func (c Config) denyBodyBytes() []byte {
+	if c.DenyResponseJSON == nil {
+		return nil
+	}
+	body, _ := json.Marshal(c.DenyResponseJSON)
+	return body
+}
+
+// deniedHostnames returns the server hostnames denied as origins.
+func (c Config) deniedHostnames() []string {
+	if !c.DenyServerHostnameOrigins {
+		return nil
+	}
+	return normalize(c.ServerHostnames)
+}
+
+func (c Config) ipResolver() *ipResolver {
+	return newIPResolver(c.OriginResolver, c.AllowOriginByResolvedIP, c.ResolvedIPCacheTTL)
+}
+
+// allowHeaderList returns AllowHeaders and the simple headers when
+// IncludeSimpleHeaders is set.
+func (c Config) allowHeaderList() []string {
+	if !c.IncludeSimpleHeaders {
+		return c.AllowHeaders
+	}
+	return append(c.AllowHeaders[:len(c.AllowHeaders):len(c.AllowHeaders)], SimpleHeaders...)
+}
+
+// compileOriginsByMethod returns OriginsByMethod keyed by uppercased method,
+// with normalized origins unless ExactMatchOnly is set.
+func (c Config) compileOriginsByMethod() map[string][]string {
+	if len(c.OriginsByMethod) == 0 {
+		return nil
+	}
+	originsByMethod := make(map[string][]string, len(c.OriginsByMethod))
+	for method, origins := range c.OriginsByMethod {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if !c.ExactMatchOnly {
+			origins = convert(normalize(origins), stripDefaultPort)
+		}
+		originsByMethod[method] = append(originsByMethod[method], origins...)
+	}
+	return originsByMethod
+}
+
+// indexOrigins moves the subdomain wildcard rules to a trie, the others
+// being matched linearly, and strips the www. prefix of the exact origins
+// when ignoreWWW is set.
+func (cors *cors) indexOrigins(ignoreWWW bool) {
+	var rules [][]string
+	for _, w := range cors.wildcardOrigins {
+		if !isSuffixRule(w) {
+			rules = append(rules, w)
+			continue
+		}
+		if cors.suffixRules == nil {
+			cors.suffixRules = &suffixTrie{}
+		}
+		cors.suffixRules.insert(w)
+	}
+	cors.wildcardOrigins = rules
+	if cors.suffixRules != nil {
+		// wildcard entries never match exactly, keep large lists out of the scan
+		exactOrigins := make([]string, 0, len(cors.allowOrigins))
+		for _, origin := range cors.allowOrigins {
+			if !strings.Contains(origin, "*") {
+				exactOrigins = append(exactOrigins, origin)
+			}
+		}
+		cors.allowOrigins = exactOrigins
+	}
+	if ignoreWWW {
+		cors.wwwStrippedOrigins = convert(cors.allowOrigins, stripWWWPrefix)
+	}
+}
+
+func (cors *cors) summary(config Config) PolicySummary {
+	origins := 0
+	for _, origin := range cors.allowOrigins {
+		if origin != "*" && !isRegexpOrigin(origin) && (!config.AllowWildcard || !strings.Contains(origin, "*")) {
+			origins++
+		}
+	}
+	wildcardOrigins := len(cors.wildcardOrigins)
+	if cors.suffixRules != nil {
+		wildcardOrigins += cors.suffixRules.rules
+	}
+	hasOriginFunc := cors.allowOriginFunc != nil || cors.allowOriginWithContextFunc != nil ||
+		cors.allowOriginTokenFunc != nil || cors.allowOriginByClientCert != nil || cors.resolvedIPs != nil
+	return PolicySummary{
+		AllowAllOrigins:     cors.allowAllOrigins,
+		AllowCredentials:    cors.allowCredentials,
+		AllowPrivateNetwork: config.AllowPrivateNetwork,
+		AllowLocalhost:      cors.allowLocalhost,
+		HasOriginFunc:       hasOriginFunc,
+		Origins:             origins,
+		WildcardOrigins:     wildcardOrigins,
+		RegexpOrigins:       len(cors.regexpOrigins),
+		AllowMethods:        len(cors.allowMethods),
+		AllowHeaders:        len(normalize(config.AllowHeaders)),
+		ExposeHeaders:       len(normalize(config.ExposeHeaders)),
+		MaxAge:              config.getMaxAge(),
+	}
+}
+
+// requestOrigin returns the origin of a CORS request and false when the
+// request is not a CORS request.
+func requestOrigin(c *gin.Context) (string, bool) {
 	origin := c.Request.Header.Get("Origin")
 	if len(origin) == 0 {
 		// request is not a CORS request
-		return
+		return "", false
 	}
 	host := c.Request.Host
 
 	if origin == "http://"+host || origin == "https://"+host {
 		// request is not a CORS request but have origin header.
 		// for example, use fetch api
+		return "", false
+	}
+	return origin, true
+}
+
+// hasEmptyOrigin reports whether the request has an Origin header with an
+// empty value.
+func hasEmptyOrigin(c *gin.Context) bool {
+	values, ok := c.Request.Header["Origin"]
+	return ok && len(values) > 0 && values[0] == ""
+}
+
+func (cors *cors) applyCors(c *gin.Context) {
+	origin, ok := cors.originToCheck(c)
+	if !ok {
+		return
+	}
+
+	valid, err := cors.checkOrigin(c, origin)
+	if err != nil {
+		cors.originFuncPanicked(c, origin, err)
+		return
+	}
+	if !valid && cors.denyOrReport(c, http.StatusForbidden, origin, DenialOriginNotAllowed) {
+		return
+	}
+
+	cors.applyAllowed(c, origin)
+}
+
+// originToCheck returns the origin of a CORS request to check against the
+// allowed origins. It handles the requests left before the origin check:
+// manual preflights, requests without origin and responses already written.
+func (cors *cors) originToCheck(c *gin.Context) (string, bool) {
+	if cors.manualPreflight && c.Request.Method == http.MethodOptions {
+		return "", false
+	}
+
+	origin, ok := requestOrigin(c)
+	if !ok {
+		if cors.treatEmptyOriginAsCORS && hasEmptyOrigin(c) {
+			cors.denyOrReport(c, http.StatusForbidden, "", DenialOriginNotAllowed)
+		} else if cors.fallbackToHostOrigin && c.Request.Header.Get("Origin") == "" {
+			cors.applyHostOrigin(c)
+		}
+		return "", false
+	}
+
+	if cors.newOrigins != nil {
+		cors.newOrigins.observe(origin)
+	}
+
+	if c.Writer.Written() {
+		cors.headersWritten(c, origin)
+		return "", false
+	}
+	return origin, true
+}
+
+// applyHostOrigin sets the CORS headers of an actual request for the origin
+// built from the scheme and Host of a request without Origin, when allowed.
+func (cors *cors) applyHostOrigin(c *gin.Context) {
+	if c.Request.Host == "" || c.Writer.Written() {
 		return
 	}
+	origin := "http://" + c.Request.Host
+	if c.Request.TLS != nil {
+		origin = "https://" + c.Request.Host
+	}
+	if valid, err := cors.checkOrigin(c, origin); err != nil || !valid {
+		return
+	}
+	cors.handleNormal(c, origin)
+	cors.applyAllowOrigin(c, origin)
+}
+
+// headersWritten reports a CORS request reaching the middleware after the
+// response was written, e.g. flushed by a previous handler, since any
+// header set now would be silently dropped.
+func (cors *cors) headersWritten(c *gin.Context, origin string) {
+	_ = c.Error(ErrHeadersWritten)
+	cors.logger.Printf("[CORS] response already written for %s %s from origin %s, CORS headers not sent",
+		c.Request.Method, c.Request.URL.Path, origin)
+}
 
-	if !cors.isOriginValid(c, origin) {
-		c.AbortWithStatus(http.StatusForbidden)
+// applyAllowed handles a CORS request whose origin has been validated.
+func (cors *cors) applyAllowed(c *gin.Context, origin string) {
+	if cors.deniedSecFetchSite(c, origin) {
 		return
 	}
 
-	if c.Request.Method == "OPTIONS" {
-		cors.handlePreflight(c)
-		defer c.AbortWithStatus(cors.optionsResponseStatusCode)
+	if cors.isPreflight(c) {
+		extraHeaders, ok := cors.checkPreflight(c, origin)
+		if !ok {
+			return
+		}
+		cors.handlePreflight(c, origin, extraHeaders)
+		defer cors.abortPreflight(c)
 	} else {
-		cors.handleNormal(c)
+		if cors.enforceMethod && !cors.isMethodAllowed(c.Request.Method) &&
+			cors.denyOrReport(c, http.StatusForbidden, origin, DenialMethodNotAllowed) {
+			return
+		}
+		cors.handleNormal(c, origin)
+	}
+
+	cors.applyAllowOrigin(c, origin)
+
+	if cors.afterApply != nil {
+		cors.afterApply(c, true)
+	}
+}
+
+// deniedSecFetchSite reports whether the request was denied for its
+// Sec-Fetch-Site header.
+func (cors *cors) deniedSecFetchSite(c *gin.Context, origin string) bool {
+	return len(cors.secFetchSites) > 0 && !cors.isSecFetchSiteAllowed(c) &&
+		cors.denyOrReport(c, http.StatusForbidden, origin, DenialSecFetchSite)
+}
+
+// checkPreflight denies the preflight from the allowed origin when it requests
+// too many headers or a "*" header that is not allowed. It returns the headers allowed for the
+// request besides AllowHeaders, and false when the preflight was denied.
+func (cors *cors) checkPreflight(c *gin.Context, origin string) ([]string, bool) {
+	if cors.maxRequestHeaders > 0 &&
+		countHeaderList(c.Request.Header.Get("Access-Control-Request-Headers")) > cors.maxRequestHeaders &&
+		cors.denyOrReport(c, http.StatusForbidden, origin, DenialTooManyHeaders) {
+		return nil, false
+	}
+	extraHeaders := cors.requestAllowHeaders(c, origin)
+	if !cors.allowAnyHeader && !cors.echoHeaderIntersection && !cors.reflectRequestHeaders &&
+		!cors.allowExtraHeaders && !containsAnyHeader(extraHeaders) && requestsAnyHeader(c) &&
+		cors.denyOrReport(c, http.StatusForbidden, origin, DenialHeaderNotAllowed) {
+		return nil, false
+	}
+	return extraHeaders, true
+}
+
+// applyAllowOrigin sets Access-Control-Allow-Origin and the credentials
+// header depending on origin.
+func (cors *cors) applyAllowOrigin(c *gin.Context, origin string) {
+	if !cors.allowOriginStar {
+		c.Header("Access-Control-Allow-Origin", cors.reflectedOrigin(origin))
 	}
 
-	if !cors.allowAllOrigins {
-		c.Header("Access-Control-Allow-Origin", origin)
+	if cors.allowCredentialsFunc != nil {
+		cors.applyCredentials(c, origin)
+	}
+
+	if cors.allowOriginValueFunc != nil {
+		cors.applyAllowOriginValue(c, origin)
 	}
 }
 
+func (cors *cors) applyCredentials(c *gin.Context, origin string) {
+	if cors.allowAllOrigins {
+		addVary(c.Writer.Header(), "Origin")
+	}
+	if !cors.allowCredentialsFunc(origin) || cors.omitCredentials(c) {
+		return
+	}
+	c.Header("Access-Control-Allow-Credentials", "true")
+	if cors.allowAllOrigins {
+		c.Header("Access-Control-Allow-Origin", cors.reflectedOrigin(origin))
+	}
+}
+
+// omitCredentials reports whether Access-Control-Allow-Credentials is left out
+// of the response, because it is a preflight response and credentials are
+// only sent on actual ones, or the actual request carries no credentials.
+func (cors *cors) omitCredentials(c *gin.Context) bool {
+	if cors.isPreflight(c) {
+		return cors.credentialsOnActualOnly
+	}
+	if !cors.credentialsOnlyWhenPresent {
+		return false
+	}
+	return c.Request.Header.Get("Cookie") == "" && c.Request.Header.Get("Authorization") == ""
+}
+
+// applyAllowOriginValue replaces Access-Control-Allow-Origin with the value
+// returned by allowOriginValueFunc.
+func (cors *cors) applyAllowOriginValue(c *gin.Context, origin string) {
+	header := c.Writer.Header()
+	if cors.allowOriginStar && cors.allowCredentialsFunc == nil {
+		// applyCredentials already varies on Origin
+		addVary(header, "Origin")
+	}
+	if value := cors.allowOriginValueFunc(origin); value != "" {
+		header.Set("Access-Control-Allow-Origin", value)
+	} else {
+		header.Del("Access-Control-Allow-Origin")
+	}
+}
+
+// reflectedOrigin returns the Access-Control-Allow-Origin value for origin.
+func (cors *cors) reflectedOrigin(origin string) string {
+	if !cors.canonicalizeOrigin {
+		return origin
+	}
+	return canonicalOrigin(origin)
+}
+
+// requestAllowHeaders returns the headers allowed for the preflight request c
+// from origin by the RouteAllowHeaders context value and AllowHeadersFunc.
+func (cors *cors) requestAllowHeaders(c *gin.Context, origin string) []string {
+	var headers []string
+	if v, ok := c.Get(RouteAllowHeaders); ok {
+		headers, _ = v.([]string)
+	}
+	if cors.allowHeadersFunc != nil {
+		headers = append(headers[:len(headers):len(headers)], cors.allowHeadersFunc(c, origin)...)
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return headerNames(headers, cors.preserveHeaderCase)
+}
+
+// containsAnyHeader reports whether names contains the "*" header name.
+func containsAnyHeader(names []string) bool {
+	for _, name := range names {
+		if name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// requestsAnyHeader reports whether the preflight requests the "*" header.
+func requestsAnyHeader(c *gin.Context) bool {
+	for _, name := range parseHeaderList(c.Request.Header.Get("Access-Control-Request-Headers")) {
+		if name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (cors *cors) isSecFetchSiteAllowed(c *gin.Context) bool {
+	site := strings.ToLower(c.Request.Header.Get("Sec-Fetch-Site"))
+	if site == "" {
+		return cors.allowMissingSecFetchSite
+	}
+	for _, value := range cors.secFetchSites {
+		if value == site {
+			return true
+		}
+	}
+	return false
+}
+
+func (cors *cors) isPreflight(c *gin.Context) bool {
+	if c.Request.Method != "OPTIONS" {
+		return false
+	}
+	if len(cors.noPreflightMethods) > 0 &&
+		cors.isNoPreflightMethod(c.Request.Header.Get("Access-Control-Request-Method")) {
+		return false
+	}
+	if cors.passNonPreflightOptions {
+		return c.Request.Header.Get("Access-Control-Request-Method") != ""
+	}
+	return true
+}
+
+// denyOrReport reports the denial and denies the request unless in report-only
+// mode. It returns true when the request has been denied.
+func (cors *cors) denyOrReport(c *gin.Context, status int, origin, reason string) bool {
+	if cors.reportFunc != nil {
+		cors.reportFunc(origin, reason)
+	}
+	if cors.reportOnly {
+		return false
+	}
+	cors.deny(c, status, origin, reason)
+	return true
+}
+
+func (cors *cors) deny(c *gin.Context, status int, origin, reason string) {
+	c.Set(denialContextKey, denial{origin: origin, reason: reason})
+	if cors.deniedStatusFunc != nil {
+		if s := cors.deniedStatusFunc(c, origin, reason); s != 0 {
+			status = s
+		}
+	}
+	if cors.debugMode {
+		c.Header("X-CORS-Attempted-Origin", origin)
+		c.Header("X-CORS-Denial-Reason", reason)
+	}
+	if cors.afterApply != nil {
+		cors.afterApply(c, false)
+	}
+	if cors.silentPreflightDeny && cors.isPreflight(c) {
+		// answered like an allowed preflight, without any CORS header
+		c.AbortWithStatus(cors.optionsResponseStatusCode)
+		return
+	}
+	if cors.denyBody == nil {
+		c.AbortWithStatus(status)
+		return
+	}
+	c.Abort()
+	c.Data(status, "application/json; charset=utf-8", cors.denyBody)
+}
+
 func (cors *cors) validateWildcardOrigin(origin string) bool {
+	if len(cors.wildcardApexes) > 0 {
+		u, err := url.Parse(origin)
+		if err != nil || !isWithinApexes(u.Hostname(), cors.wildcardApexes, cors.wildcardMatchesApex) {
+			return false
+		}
+	}
+	if cors.suffixRules != nil && cors.suffixRules.match(origin, cors.wildcardMatchesApex) {
+		return true
+	}
 	for _, w := range cors.wildcardOrigins {
-		if w[0] == "*" && strings.HasSuffix(origin, w[1]) {
-			return true
+		origin := origin
+		if suffix, ok := strings.CutSuffix(w[1], ":*"); ok {
+			// the rule allows any port
+			origin = stripPort(origin)
+			w = []string{w[0], suffix}
 		}
-		if w[1] == "*" && strings.HasPrefix(origin, w[0]) {
+		if matchesWildcard(origin, w) {
 			return true
 		}
-		if strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
+		if cors.wildcardMatchesApex && matchesWildcardApex(origin, w) {
 			return true
 		}
 	}
@@ -114,24 +766,218 @@ func (cors *cors) validateWildcardOrigin(origin string) bool {
 	return false
 }
 
+func matchesWildcard(origin string, w []string) bool {
+	if w[0] == "*" {
+		return strings.HasSuffix(origin, w[1])
+	}
+	if w[1] == "*" {
+		return strings.HasPrefix(origin, w[0])
+	}
+	return strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1])
+}
+
+// matchesWildcardApex reports whether origin is the apex of a subdomain
+// wildcard rule, e.g. https://example.com for https://*.example.com
+func matchesWildcardApex(origin string, w []string) bool {
+	if !strings.HasPrefix(w[1], ".") {
+		return false
+	}
+	apex := w[1][1:]
+	if w[0] == "*" {
+		return strings.HasSuffix(origin, "://"+apex)
+	}
+	if strings.HasSuffix(w[0], "://") {
+		return origin == w[0]+apex
+	}
+	return false
+}
+
+func (cors *cors) validateGlobOrigin(origin string) bool {
+	origin = asciiLower(origin)
+	for _, pattern := range cors.originGlobs {
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin is like isOriginValid but returns an error when a user
+// defined origin func panics.
+func (cors *cors) checkOrigin(c *gin.Context, origin string) (valid bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			valid = false
+			err = fmt.Errorf("cors: origin func panicked: %v", r)
+		}
+	}()
+	return cors.isOriginValid(c, origin), nil
+}
+
+func (cors *cors) originFuncPanicked(c *gin.Context, origin string, err error) {
+	if cors.onOriginFuncPanic != nil {
+		cors.onOriginFuncPanic(c, err)
+	}
+	cors.deny(c, cors.originFuncPanicStatusCode, origin, DenialOriginFuncPanic)
+}
+
 func (cors *cors) isOriginValid(c *gin.Context, origin string) bool {
+	if cors.requireHTTPS && isHTTPOrigin(origin) {
+		return false
+	}
+	if cors.requireSNI && !originMatchesSNI(c.Request, origin) {
+		return false
+	}
+	if cors.requireSchemeMatch && !originMatchesScheme(c.Request, origin) {
+		return false
+	}
+	if len(cors.serverHostnames) > 0 && cors.isServerHostnameOrigin(origin) &&
+		!(cors.allowSameOrigin && isSameOrigin(origin, c.Request.Host)) {
+		return false
+	}
 	valid := cors.validateOrigin(origin)
+	if !valid && cors.originsByMethod != nil {
+		valid = cors.validateMethodOrigin(c, origin)
+	}
+	if !valid && cors.allowSameOrigin {
+		valid = isSameOrigin(origin, c.Request.Host)
+	}
+	if !valid && cors.allowOriginTokenFunc != nil {
+		valid = cors.validateTokenOrigin(c, origin)
+	}
+	if !valid && cors.allowOriginByClientCert != nil {
+		if tls := c.Request.TLS; tls != nil && len(tls.PeerCertificates) > 0 {
+			valid = cors.allowOriginByClientCert(origin, tls.PeerCertificates[0])
+		}
+	}
+	if !valid && cors.resolvedIPs != nil {
+		valid = cors.resolvedIPs.allowed(c.Request.Context(), origin)
+	}
 	if !valid && cors.allowOriginWithContextFunc != nil {
 		valid = cors.allowOriginWithContextFunc(c, origin)
 	}
 	return valid
 }
 
+// isServerHostnameOrigin reports whether the host of origin is one of the
+// server hostnames.
+func (cors *cors) isServerHostnameOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	hostname := strings.TrimSuffix(asciiLower(u.Hostname()), ".")
+	for _, value := range cors.serverHostnames {
+		if value == hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTokenOrigin reports whether the origin named by the request token
+// is origin.
+func (cors *cors) validateTokenOrigin(c *gin.Context, origin string) bool {
+	tokenOrigin, ok := cors.allowOriginTokenFunc(c)
+	if !ok {
+		return false
+	}
+	if cors.exactMatchOnly {
+		return tokenOrigin != "" && tokenOrigin == origin
+	}
+	canonical := func(o string) string {
+		return stripDefaultPort(asciiLower(strings.TrimSpace(o)))
+	}
+	return tokenOrigin != "" && canonical(tokenOrigin) == canonical(origin)
+}
+
+// validateStoreOrigin matches origin against the current OriginStore list,
+// normalized like AllowOrigins unless exactMatchOnly is set.
+func (cors *cors) validateStoreOrigin(origin string) bool {
+	if cors.originStore == nil {
+		return false
+	}
+	for _, value := range cors.originStore.Origins() {
+		if !cors.exactMatchOnly {
+			value = stripDefaultPort(asciiLower(strings.TrimSpace(value)))
+		}
+		if value == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (cors *cors) validateMethodOrigin(c *gin.Context, origin string) bool {
+	method := c.Request.Method
+	if method == http.MethodOptions {
+		if requested := c.Request.Header.Get("Access-Control-Request-Method"); requested != "" {
+			method = requested
+		}
+	}
+	if !cors.exactMatchOnly {
+		origin = stripDefaultPort(origin)
+	}
+	for _, value := range cors.originsByMethod[strings.ToUpper(method)] {
+		if value == "*" || value == origin {
+			return true
+		}
+	}
+	return false
+}
+
 func (cors *cors) validateOrigin(origin string) bool {
 	if cors.allowAllOrigins {
 		return true
 	}
+	if cors.exactMatchOnly {
+		for _, value := range cors.allowOrigins {
+			if value == origin {
+				return true
+			}
+		}
+		if cors.validateStoreOrigin(origin) {
+			return true
+		}
+		if cors.allowOriginFunc != nil {
+			return cors.allowOriginFunc(origin)
+		}
+		return false
+	}
+	exact := normalizeIPv6Host(stripDefaultPort(origin))
 	for _, value := range cors.allowOrigins {
-		if value == origin {
+		if value == exact {
+			return true
+		}
+	}
+	if cors.validateStoreOrigin(exact) {
+		return true
+	}
+	for _, r := range cors.portRangeOrigins {
+		if r.match(exact) {
+			return true
+		}
+	}
+	if len(cors.wwwStrippedOrigins) > 0 {
+		stripped := stripWWWPrefix(exact)
+		for _, value := range cors.wwwStrippedOrigins {
+			if value == stripped {
+				return true
+			}
+		}
+	}
+	if (len(cors.wildcardOrigins) > 0 || cors.suffixRules != nil) && cors.validateWildcardOrigin(origin) {
+		return true
+	}
+	for _, re := range cors.regexpOrigins {
+		if re.MatchString(origin) {
 			return true
 		}
 	}
-	if len(cors.wildcardOrigins) > 0 && cors.validateWildcardOrigin(origin) {
+	if len(cors.originGlobs) > 0 && cors.validateGlobOrigin(origin) {
+		return true
+	}
+	if cors.allowLocalhost && isLocalhostOrigin(origin) {
 		return true
 	}
 	if cors.allowOriginFunc != nil {
@@ -140,16 +986,202 @@ func (cors *cors) validateOrigin(origin string) bool {
 	return false
 }
 
-func (cors *cors) handlePreflight(c *gin.Context) {
+func (cors *cors) isNoPreflightMethod(method string) bool {
+	for _, m := range cors.noPreflightMethods {
+		if m == method || !cors.strictMethodCase && strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cors *cors) isMethodAllowed(method string) bool {
+	if cors.allowAnyMethod {
+		return true
+	}
+	for _, m := range cors.allowMethods {
+		if m == method || !cors.strictMethodCase && strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cors *cors) handlePreflight(c *gin.Context, origin string, extraHeaders []string) {
 	header := c.Writer.Header()
-	for key, value := range cors.preflightHeaders {
-		header[key] = value
+	for _, entry := range cors.preflightHeaders {
+		header[entry.key] = entry.values
+	}
+	if cors.idempotentMaxAge != "" {
+		// the max age depends on the requested method
+		cors.addStarVary(header, "Access-Control-Request-Method")
+		if cors.requestsIdempotentMethod(c) {
+			header.Set("Access-Control-Max-Age", cors.idempotentMaxAge)
+		}
+	}
+	if maxAge, ok := cors.maxAgeByOrigin[asciiLower(origin)]; ok {
+		if maxAge == "" {
+			header.Del("Access-Control-Max-Age")
+		} else {
+			header.Set("Access-Control-Max-Age", maxAge)
+		}
+	}
+	if cors.echoMethodIntersection || cors.allowAnyMethod {
+		cors.echoRequestedMethod(c)
+	}
+	if cors.reflectRequestHeaders {
+		cors.reflectAllowHeaders(c)
+	} else if cors.echoHeaderIntersection {
+		cors.echoAllowHeaders(c, extraHeaders)
+	} else if cors.allowExtraHeaders || len(extraHeaders) > 0 {
+		cors.addExtraAllowHeaders(c, extraHeaders)
+	}
+	if cors.maxAllowHeadersBytes > 0 && len(header.Get("Access-Control-Allow-Headers")) > cors.maxAllowHeadersBytes {
+		cors.resetAllowHeaders(header)
+	}
+	if cors.credentialsOnActualOnly {
+		header.Del("Access-Control-Allow-Credentials")
+	}
+	if cors.omitUnrequestedHeaders {
+		if c.Request.Header.Get("Access-Control-Request-Headers") == "" {
+			header.Del("Access-Control-Allow-Headers")
+		}
+		cors.addStarVary(header, "Access-Control-Request-Headers")
+	}
+	if cors.strictMode && cors.allowPrivateNetwork &&
+		c.Request.Header.Get("Access-Control-Request-Private-Network") == "true" {
+		header.Set("Access-Control-Allow-Private-Network", "true")
+	}
+}
+
+// resetAllowHeaders sets Access-Control-Allow-Headers back to the
+// precomputed AllowHeaders.
+func (cors *cors) resetAllowHeaders(header http.Header) {
+	header.Del("Access-Control-Allow-Headers")
+	for _, entry := range cors.preflightHeaders {
+		if entry.key == "Access-Control-Allow-Headers" {
+			header[entry.key] = entry.values
+		}
+	}
+}
+
+// requestsIdempotentMethod reports whether the preflight c requests GET or
+// HEAD.
+func (cors *cors) requestsIdempotentMethod(c *gin.Context) bool {
+	method := c.Request.Header.Get("Access-Control-Request-Method")
+	if cors.strictMethodCase {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return strings.EqualFold(method, http.MethodGet) || strings.EqualFold(method, http.MethodHead)
+}
+
+// echoRequestedMethod sets Access-Control-Allow-Methods to the requested
+// method when it is allowed.
+func (cors *cors) echoRequestedMethod(c *gin.Context) {
+	method := c.Request.Header.Get("Access-Control-Request-Method")
+	if method == "" || !cors.isMethodAllowed(method) {
+		return
+	}
+	header := c.Writer.Header()
+	header.Set("Access-Control-Allow-Methods", method)
+	cors.addStarVary(header, "Access-Control-Request-Method")
+}
+
+func (cors *cors) reflectAllowHeaders(c *gin.Context) {
+	value := c.Request.Header.Get("Access-Control-Request-Headers")
+	if cors.strictMode {
+		value = strings.Join(c.Request.Header.Values("Access-Control-Request-Headers"), ",")
+	}
+	requested := parseHeaderList(value)
+	if len(requested) == 0 {
+		return
 	}
+	header := c.Writer.Header()
+	header.Set("Access-Control-Allow-Headers", strings.Join(requested, cors.listSeparator))
+	cors.addStarVary(header, "Access-Control-Request-Headers")
 }
 
-func (cors *cors) handleNormal(c *gin.Context) {
+// echoAllowHeaders sets Access-Control-Allow-Headers to the requested headers
+// that are allowed by allowHeaders or extraHeaders, so that a preflight also
+// requesting other headers succeeds and the browser blocks the request.
+func (cors *cors) echoAllowHeaders(c *gin.Context, extraHeaders []string) {
+	allowed := make(map[string]string, len(cors.allowHeaders)+len(extraHeaders))
+	for _, name := range append(cors.allowHeaders[:len(cors.allowHeaders):len(cors.allowHeaders)], extraHeaders...) {
+		allowed[asciiLower(name)] = name
+	}
+	_, allowAny := allowed["*"]
+	var names []string
+	for _, name := range parseHeaderList(c.Request.Header.Get("Access-Control-Request-Headers")) {
+		if value, ok := allowed[asciiLower(name)]; ok {
+			names = append(names, value)
+		} else if allowAny {
+			names = append(names, name)
+		}
+	}
+	header := c.Writer.Header()
+	if len(names) == 0 {
+		header.Del("Access-Control-Allow-Headers")
+	} else {
+		header.Set("Access-Control-Allow-Headers", strings.Join(names, cors.listSeparator))
+	}
+	cors.addStarVary(header, "Access-Control-Request-Headers")
+}
+
+// addExtraAllowHeaders merges extraHeaders, and the requested headers when
+// AllowExtraRequestedHeaders is set, into Access-Control-Allow-Headers.
+func (cors *cors) addExtraAllowHeaders(c *gin.Context, extraHeaders []string) {
+	var requested []string
+	if cors.allowExtraHeaders {
+		requested = parseHeaderList(c.Request.Header.Get("Access-Control-Request-Headers"))
+	}
+	if len(requested) == 0 && len(extraHeaders) == 0 {
+		return
+	}
+	allowHeaders := append(cors.allowHeaders[:len(cors.allowHeaders):len(cors.allowHeaders)], extraHeaders...)
+	allowHeaders = headerNames(append(allowHeaders, requested...), true)
+	header := c.Writer.Header()
+	header.Set("Access-Control-Allow-Headers", strings.Join(allowHeaders, cors.listSeparator))
+	if len(requested) > 0 {
+		cors.addStarVary(header, "Access-Control-Request-Headers")
+	}
+}
+
+// addStarVary adds value to the Vary header of a preflight response allowing
+// all origins, since the precomputed headers only vary on the preflight request
+// headers in strict mode.
+func (cors *cors) addStarVary(header http.Header, value string) {
+	if cors.allowOriginStar && !cors.strictMode && !cors.minimalHeaders {
+		addVary(header, value)
+	}
+}
+
+func (cors *cors) abortPreflight(c *gin.Context) {
+	if cors.preflightBody == nil {
+		c.AbortWithStatus(cors.optionsResponseStatusCode)
+		return
+	}
+	c.Abort()
+	c.Data(cors.optionsResponseStatusCode, "text/plain; charset=utf-8", cors.preflightBody)
+}
+
+func (cors *cors) handleNormal(c *gin.Context, origin string) {
 	header := c.Writer.Header()
 	for key, value := range cors.normalHeaders {
 		header[key] = value
 	}
+	if cors.credentialsOnlyWhenPresent {
+		// Access-Control-Allow-Credentials depends on the request credentials
+		addVary(header, "Cookie")
+		addVary(header, "Authorization")
+	}
+	if cors.omitCredentials(c) {
+		header.Del("Access-Control-Allow-Credentials")
+	}
+	if cors.exposeHeadersFunc == nil {
+		return
+	}
+	if exposeHeaders := cors.exposeHeadersFunc(origin); len(exposeHeaders) > 0 {
+		exposeHeaders = headerNames(exposeHeaders, cors.preserveHeaderCase)
+		header.Set("Access-Control-Expose-Headers", strings.Join(exposeHeaders, cors.listSeparator))
+	}
 }
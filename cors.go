@@ -1,8 +1,18 @@
 package cors
 
 import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,50 +24,308 @@ type Config struct {
 	AllowAllOrigins bool
 
 	// AllowOrigins is a list of origins a cross-domain request can be executed from.
-	// If the special "*" value is present in the list, all origins will be allowed.
+	// The special "*" value allows all origins, like AllowAllOrigins, and must
+	// be the only entry: Validate rejects lists mixing it with other origins.
+	// Entries of the form /pattern/flags are matched as regular expressions; the
+	// flags i, s and m are supported and g is ignored. A port range such as
+	// http://localhost:3000-3010 matches any port of the range, bounds included.
 	// Default value is []
 	AllowOrigins []string
 
+	// AllowOriginsCSV is a comma separated list of origins, for configurations
+	// loaded from strings such as environment variables. It is only used when
+	// AllowOrigins is empty. Whitespace around entries is ignored.
+	AllowOriginsCSV string
+
+	// ExactMatchOnly compares origins byte for byte with AllowOrigins,
+	// AllowOriginURLs and OriginsByMethod: no case folding, trimming or default
+	// port stripping. Options relying on fuzzy matching (wildcards, regular
+	// expressions, port ranges, globs, www, localhost and same origin matching)
+	// are rejected by Validate.
+	ExactMatchOnly bool
+
+	// OriginsByMethod lists additional origins allowed only for a given method.
+	// For preflight requests the method is read from Access-Control-Request-Method.
+	// A "*" entry allows any origin for that method.
+	OriginsByMethod map[string][]string
+
+	// OriginStore provides allowed origins that can change at runtime, e.g. from
+	// a key-value store. Its list is read on every CORS request and matched like
+	// AllowOrigins entries.
+	OriginStore OriginStore
+
+	// AllowOriginURLs is a list of origins given as URL values. Each URL is
+	// normalized to scheme://host[:port] with default ports dropped and added
+	// to AllowOrigins. URLs must not contain a path.
+	AllowOriginURLs []*url.URL
+
+	// IgnoreWWWPrefix makes AllowOrigins entries match origins with or without a
+	// leading "www." in the host, e.g. https://example.com also allows
+	// https://www.example.com and the other way around.
+	IgnoreWWWPrefix bool
+
+	// AllowOriginGlobs is a list of glob patterns matched against the origin using
+	// path.Match syntax: '*' matches any sequence of characters except '/', '?'
+	// matches a single character and [a-z] matches a character class, so
+	// https://*.example.com and https://app-?.example.com are valid patterns.
+	// Matching is case-insensitive.
+	AllowOriginGlobs []string
+
+	// AllowSameOrigin allows origins whose host and port match the request Host,
+	// ignoring case and default ports, regardless of the other origin settings.
+	AllowSameOrigin bool
+
+	// RequireHTTPS rejects http:// entries in AllowOrigins, AllowOriginURLs,
+	// OriginsByMethod and AllowOriginGlobs, and denies http:// request origins
+	// even if a wildcard or origin func would allow them.
+	RequireHTTPS bool
+
+	// RequireOriginMatchesSNI denies request origins whose host is not the TLS
+	// server name (SNI) sent by the client, and all requests not made over TLS.
+	RequireOriginMatchesSNI bool
+
+	// ServerHostnames lists the public hostnames of the server, e.g.
+	// api.example.com, for DenyServerHostnameOrigins.
+	ServerHostnames []string
+
+	// DenyServerHostnameOrigins denies cross-origin requests whose origin host
+	// is one of ServerHostnames, since a request claiming to come from the
+	// server itself is suspicious in gateway setups. Same-origin requests are
+	// not affected, nor origins allowed by AllowSameOrigin.
+	DenyServerHostnameOrigins bool
+
+	// RequireSchemeMatch denies request origins whose scheme is not the scheme
+	// the request was received with: https when it was made over TLS, http
+	// otherwise. Origins with other schemes are always denied.
+	RequireSchemeMatch bool
+
+	// AllowLocalhost allows http and https origins on any port whose host is
+	// localhost, a loopback address such as 127.0.0.1 or [::1], or 0.0.0.0.
+	AllowLocalhost bool
+
+	// MaxOrigins makes Validate reject configs with more distinct AllowOrigins
+	// entries, as a guard for generated configs. Default value is 0 (unlimited)
+	MaxOrigins int
+
 	// AllowOriginFunc is a custom function to validate the origin. It takes the origin
-	// as an argument and returns true if allowed or false otherwise. If this option is
-	// set, the content of AllowOrigins is ignored.
+	// as an argument and returns true if allowed or false otherwise. It is only
+	// called for origins not allowed by AllowOrigins and the other origin lists,
+	// so it can extend them but not deny an origin they allow.
 	AllowOriginFunc func(origin string) bool
 
+	// AllowOriginFuncs are evaluated in order after AllowOriginFunc, like it,
+	// and the origin is allowed as soon as one of them returns true. They let
+	// independent origin rules be composed instead of merged into one func.
+	AllowOriginFuncs []func(origin string) bool
+
 	// Same as AllowOriginFunc except also receives the full request context.
 	// This function should use the context as a read only source and not
 	// have any side effects on the request, such as aborting or injecting
 	// values on the request.
 	AllowOriginWithContextFunc func(c *gin.Context, origin string) bool
 
+	// AllowOriginTokenFunc extracts and verifies a signed token carried by the
+	// request, e.g. a JWT in a custom header, and returns the origin it names.
+	// The request is allowed when ok is true and that origin is the request
+	// Origin, ignoring case and the default port unless ExactMatchOnly is set.
+	AllowOriginTokenFunc func(c *gin.Context) (origin string, ok bool)
+
+	// AllowOriginByClientCert is called with the first TLS peer certificate of
+	// the request, when there is one, for origins not allowed otherwise. It can
+	// tie origins to client certificates, e.g. by fingerprint.
+	AllowOriginByClientCert func(origin string, cert *x509.Certificate) bool
+
+	// AllowOriginByResolvedIP allows origins not allowed otherwise when one of
+	// the IP addresses their host resolves to is allowed. Lookups add the DNS
+	// latency to the first request from each host, and the allowlist is only as
+	// trustworthy as the resolver: whoever controls the DNS of a host can point
+	// it to an allowed address. Prefer it for internal networks with a trusted
+	// resolver.
+	AllowOriginByResolvedIP func(ip net.IP) bool
+
+	// OriginResolver resolves origin hosts for AllowOriginByResolvedIP.
+	// Default value is net.DefaultResolver
+	OriginResolver Resolver
+
+	// ResolvedIPCacheTTL is how long the addresses of an origin host are cached
+	// for AllowOriginByResolvedIP. Failed lookups are not cached. Default value
+	// is one minute
+	ResolvedIPCacheTTL time.Duration
+
+	// OriginFuncPanicStatusCode is the status used to deny a request when
+	// a callback checking the origin panics. Default value is 403
+	OriginFuncPanicStatusCode int
+
+	// OnOriginFuncPanic is called with the recovered panic, as an error, when
+	// a callback checking the origin panics: AllowOriginFunc, AllowOriginFuncs,
+	// AllowOriginWithContextFunc, AllowOriginTokenFunc, AllowOriginByClientCert,
+	// AllowOriginByResolvedIP and its OriginResolver, or OriginStore.
+	OnOriginFuncPanic func(c *gin.Context, err error)
+
 	// AllowMethods is a list of methods the client is allowed to use with
 	// cross-domain requests. Default value is simple methods (GET, POST, PUT, PATCH, DELETE, HEAD, and OPTIONS)
 	AllowMethods []string
 
+	// AdvertisedMethods is sent in Access-Control-Allow-Methods instead of
+	// AllowMethods, which is still the list enforced on actual requests when
+	// EnforceMethodOnActualRequest is set. Default value is AllowMethods
+	AdvertisedMethods []string
+
 	// AllowPrivateNetwork indicates whether the response should include allow private network header
 	AllowPrivateNetwork bool
 
 	// AllowHeaders is list of non simple headers the client is allowed to use with
-	// cross-domain requests.
+	// cross-domain requests. A preflight requesting the "*" header is only allowed
+	// when AllowHeaders contains "*", unless requested headers are reflected by
+	// SkipRequestHeaderValidation, AllowExtraRequestedHeaders or
+	// EchoHeaderIntersection.
 	AllowHeaders []string
 
+	// IncludeSimpleHeaders adds the CORS-safelisted request headers (Accept,
+	// Accept-Language, Content-Language and Content-Type) to AllowHeaders.
+	IncludeSimpleHeaders bool
+
+	// StrictMethodCase matches request methods against AllowMethods, which are
+	// uppercased, case-sensitively as HTTP does: a "get" request is not a GET.
+	// By default methods are matched ignoring case.
+	StrictMethodCase bool
+
+	// EmptyMethodsAllowAll allows any method when AllowMethods is empty: preflights
+	// are answered with the requested method in Access-Control-Allow-Methods.
+	EmptyMethodsAllowAll bool
+
+	// EchoMethodIntersection answers preflights with only the requested method in
+	// Access-Control-Allow-Methods when it is allowed, instead of the full list.
+	EchoMethodIntersection bool
+
+	// AllowExtraRequestedHeaders adds the headers listed in the preflight
+	// Access-Control-Request-Headers that are missing from AllowHeaders to the
+	// emitted Access-Control-Allow-Headers, e.g. headers injected by browsers.
+	AllowExtraRequestedHeaders bool
+
+	// AllowHeadersFunc returns headers allowed in addition to AllowHeaders for
+	// a preflight request from origin, e.g. computed from the scopes of the
+	// authenticated user. They are merged into the emitted
+	// Access-Control-Allow-Headers, and returning "*" allows a preflight
+	// requesting the "*" header name.
+	AllowHeadersFunc func(c *gin.Context, origin string) []string
+
+	// EchoHeaderIntersection answers preflights with the headers listed in
+	// Access-Control-Request-Headers that are allowed, instead of AllowHeaders,
+	// and does not deny preflights requesting the "*" header name. Preflights
+	// requesting disallowed headers then succeed and the browser blocks the
+	// actual request.
+	EchoHeaderIntersection bool
+
+	// OmitAllowHeadersWhenNotRequested leaves Access-Control-Allow-Headers out of
+	// preflight responses when the preflight requests no headers.
+	OmitAllowHeadersWhenNotRequested bool
+
+	// SkipRequestHeaderValidation reflects the headers listed in the preflight
+	// Access-Control-Request-Headers instead of answering with AllowHeaders, so
+	// browsers never reject a request because of an unlisted header.
+	SkipRequestHeaderValidation bool
+
+	// MaxRequestHeaders denies preflights listing more header names than this
+	// in Access-Control-Request-Headers. Default value is 0 (unlimited)
+	MaxRequestHeaders int
+
+	// MaxAllowHeadersBytes limits the length of the Access-Control-Allow-Headers
+	// value of preflight responses: when the headers reflected or added to
+	// AllowHeaders exceed it, only AllowHeaders is sent. It must not be lower
+	// than the length of AllowHeaders. Default value is 0 (unlimited)
+	MaxAllowHeadersBytes int
+
 	// AllowCredentials indicates whether the request can include user credentials like
-	// cookies, HTTP authentication or client side SSL certificates.
+	// cookies, HTTP authentication or client side SSL certificates. With
+	// AllowAllOrigins the request origin is reflected instead of "*", which
+	// browsers reject for credentialed requests, so every origin on the web
+	// gets credentialed access.
 	AllowCredentials bool
 
+	// AllowCredentialsFunc decides per allowed origin whether credentials are
+	// allowed and overrides AllowCredentials. When it returns true the origin is
+	// always reflected in Access-Control-Allow-Origin, never "*".
+	AllowCredentialsFunc func(origin string) bool
+
+	// CredentialsOnlyWhenPresent sends Access-Control-Allow-Credentials on actual
+	// requests only when they carry a Cookie or Authorization header, and varies
+	// them on both. Preflights, which never carry credentials, still get it.
+	// Browsers reject the response to a credentials: 'include' request sent
+	// before any cookie is set, such as the first request of a login flow.
+	CredentialsOnlyWhenPresent bool
+
+	// CredentialsOnActualOnly leaves Access-Control-Allow-Credentials out of
+	// preflight responses and only sends it on actual responses.
+	CredentialsOnActualOnly bool
+
+	// PreserveHeaderCase keeps the names in AllowHeaders and ExposeHeaders as
+	// configured (only trimmed and deduplicated) instead of canonicalizing them,
+	// for clients comparing header names case-sensitively.
+	PreserveHeaderCase bool
+
+	// CanonicalizeReflectedOrigin reflects the origin in Access-Control-Allow-Origin
+	// with a lowercased scheme and host and without the default port, instead
+	// of echoing the Origin header as sent.
+	CanonicalizeReflectedOrigin bool
+
+	// AllowOriginValueFunc returns the Access-Control-Allow-Origin value sent
+	// to an allowed origin, overriding the default "*" or reflected origin; the
+	// header is omitted when it returns "". Responses then vary on Origin.
+	AllowOriginValueFunc func(origin string) string
+
 	// ExposeHeaders indicates which headers are safe to expose to the API of a CORS
 	// API specification
 	ExposeHeaders []string
 
+	// ExposeHeadersFunc returns the headers to expose to each allowed origin, none
+	// when it returns an empty list. It can not be used with ExposeHeaders.
+	ExposeHeadersFunc func(origin string) []string
+
 	// MaxAge indicates how long (with second-precision) the results of a preflight request
-	// can be cached
+	// can be cached. When zero DefaultMaxAge is used, and when negative the
+	// Access-Control-Max-Age header is omitted.
 	MaxAge time.Duration
 
+	// MaxAgeSeconds is MaxAge as a number of seconds, convenient when the config
+	// is decoded from JSON or YAML. When non-zero it takes precedence over MaxAge.
+	MaxAgeSeconds int
+
+	// DefaultMaxAge is the MaxAge used when neither MaxAge nor MaxAgeSeconds is
+	// set. Default value is 0 (header omitted)
+	DefaultMaxAge time.Duration
+
+	// IdempotentMaxAge is the Access-Control-Max-Age of preflights requesting
+	// GET or HEAD, usually longer than MaxAge since these methods are safe to
+	// cache for longer. MaxAgeByOrigin still takes precedence.
+	// Default value is 0 (MaxAge is used)
+	IdempotentMaxAge time.Duration
+
+	// MaxAgeByOrigin overrides MaxAge for the listed origins. A zero duration
+	// omits Access-Control-Max-Age for that origin.
+	MaxAgeByOrigin map[string]time.Duration
+
 	// Allows to add origins like http://some-domain/*, https://api.* or http://some.*.subdomain.com
+	// A trailing :* port wildcard, as in https://*.example.com:*, also matches
+	// the origins with any port.
 	AllowWildcard bool
 
+	// WildcardMatchesApex makes a subdomain wildcard like https://*.example.com
+	// also match the apex origin https://example.com
+	WildcardMatchesApex bool
+
+	// WildcardApexes scopes AllowWildcard origins to subdomains of these
+	// domains, e.g. "example.com". Validate rejects wildcard origins not
+	// restricted to a subdomain of one of them.
+	WildcardApexes []string
+
 	// Allows usage of popular browser extensions schemas
 	AllowBrowserExtensions bool
 
+	// AllowChromeExtensionIDs is a list of Chrome extension IDs allowed as
+	// chrome-extension://<id> origins. Requires AllowBrowserExtensions.
+	AllowChromeExtensionIDs []string
+
 	// Allows to add custom schema like tauri://
 	CustomSchemas []string
 
@@ -69,6 +337,165 @@ type Config struct {
 
 	// Allows to pass custom OPTIONS response status code for old browsers / clients
 	OptionsResponseStatusCode int
+
+	// PreflightBody is written as a plain text response body for successful
+	// preflight requests. When set, OptionsResponseStatusCode defaults to 200
+	// since a 204 response cannot carry a body. Default value is "" (empty body)
+	PreflightBody string
+
+	// EnforceMethodOnActualRequest denies credentialed cross-origin requests whose
+	// method is not listed in AllowMethods. Only applies when AllowCredentials is set.
+	EnforceMethodOnActualRequest bool
+
+	// OnCompile is called once the configuration has been validated and compiled
+	// with a summary of the resulting policy.
+	OnCompile func(summary PolicySummary)
+
+	// DenyResponseJSON is marshaled as JSON and sent as the body of denied
+	// requests, both preflight and actual ones. Default value is nil (empty body)
+	DenyResponseJSON any
+
+	// RequireSecFetchSite additionally requires the Sec-Fetch-Site request header
+	// of CORS requests to be one of the listed values (same-origin, same-site,
+	// cross-site or none). Default value is [] (header not checked)
+	RequireSecFetchSite []string
+
+	// AllowMissingSecFetchSite lets requests without a Sec-Fetch-Site header pass
+	// the RequireSecFetchSite check, e.g. from older browsers.
+	AllowMissingSecFetchSite bool
+
+	// DebugMode adds X-CORS-Attempted-Origin and X-CORS-Denial-Reason headers to
+	// denied responses. Access-Control-Allow-Origin is never sent on denial.
+	// Do not enable it in production.
+	DebugMode bool
+
+	// LogNewOrigins is called the first time each distinct origin of a CORS
+	// request is seen, whether it is allowed or not. At most 10000 origins are
+	// remembered; when the limit is reached they are forgotten and may be
+	// logged again.
+	LogNewOrigins func(origin string)
+
+	// Logger receives diagnostics the middleware can not report otherwise, such
+	// as CORS headers dropped because the response was already written.
+	// Default value is the standard logger of the log package
+	Logger Logger
+
+	// SilentPreflightDeny answers denied preflights with OptionsResponseStatusCode,
+	// like allowed ones, instead of 403. The response carries no CORS header, so
+	// browsers still block the actual request, but clients retrying on errors
+	// see a success. DeniedStatusFunc and DenyResponseJSON do not apply to them.
+	SilentPreflightDeny bool
+
+	// DeniedStatusFunc chooses the status of denied requests from the origin and
+	// the denial reason, e.g. DenialOriginNotAllowed. Returning 0 keeps the
+	// default status, 403 or OriginFuncPanicStatusCode.
+	DeniedStatusFunc func(c *gin.Context, origin, reason string) int
+
+	// ReportFunc is called with the origin and reason of every denied request.
+	ReportFunc func(origin, reason string)
+
+	// ReportOnly allows requests that would be denied, as if the policy permitted
+	// them, and only calls ReportFunc. Useful to roll out a new policy.
+	ReportOnly bool
+
+	// AfterApply is called once the CORS headers of a CORS request are set and
+	// before the response is written by the middleware, with allowed false when
+	// the request is denied. It can add headers to the response.
+	AfterApply func(c *gin.Context, allowed bool)
+
+	// ManualPreflight leaves all OPTIONS requests to the route handlers, which
+	// can set the preflight headers with PreflightHeaders. Other requests are
+	// handled as usual.
+	ManualPreflight bool
+
+	// PassNonPreflightOptions treats an OPTIONS request without an
+	// Access-Control-Request-Method header as an actual request: the normal CORS
+	// headers are applied and the request reaches the route handler.
+	PassNonPreflightOptions bool
+
+	// TreatEmptyOriginAsCORS denies requests with an Origin header whose value
+	// is empty, which is malformed, instead of handling them as non CORS
+	// requests.
+	TreatEmptyOriginAsCORS bool
+
+	// LegacyHeaderFormat separates the values of Access-Control-Allow-Methods,
+	// Access-Control-Allow-Headers and Access-Control-Expose-Headers with ", "
+	// instead of ",", for byte compatible responses when migrating from
+	// libraries formatting them so.
+	LegacyHeaderFormat bool
+
+	// FallbackToHostOrigin sends the CORS headers of an actual request to
+	// requests without an Origin header, e.g. from server-to-server clients,
+	// using the origin built from the request scheme and Host when it is
+	// allowed. Requests whose host origin is not allowed are not denied.
+	FallbackToHostOrigin bool
+
+	// NoPreflightMethods lists methods whose explicit preflight is answered as
+	// an actual request: an OPTIONS request whose Access-Control-Request-Method
+	// is listed gets the normal CORS headers, without preflight validation, and
+	// reaches the route handler.
+	NoPreflightMethods []string
+
+	// PreflightResponseHeaders are added to successful preflight responses, for
+	// example CDN-Cache-Control. They can not set Access-Control-* headers.
+	PreflightResponseHeaders http.Header
+
+	// MinimalHeaders leaves out the Vary headers added to preflight responses
+	// allowing all origins without credentials, e.g. with EchoMethodIntersection,
+	// for deployments where no shared cache stores preflight responses.
+	// It can not be used with StrictMode.
+	MinimalHeaders bool
+
+	// StrictMode turns on the spec compliant behaviors that are off by default
+	// for backward compatibility:
+	//   - multiple Access-Control-Request-Headers lines are all read when reflecting
+	//     requested headers
+	//   - Access-Control-Allow-Private-Network is only sent when the preflight
+	//     carries Access-Control-Request-Private-Network: true
+	//   - preflight responses always use status 204, so OptionsResponseStatusCode
+	//     and PreflightBody can not be changed
+	//   - preflight responses always vary on Access-Control-Request-Method and
+	//     Access-Control-Request-Headers, even when all origins are allowed
+	//   - allowing all origins together with AllowCredentials is rejected by Validate
+	StrictMode bool
+}
+
+// OriginStore is a source of allowed origins that can change over time.
+// Origins must be safe for concurrent use and fast, as it is called per request.
+type OriginStore interface {
+	Origins() []string
+}
+
+// Logger is implemented by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// RouteAllowHeaders is the context key of headers allowed in addition to
+// AllowHeaders for a preflight request, as a []string set before the
+// middleware runs, e.g. by a middleware reading route annotations. They are
+// merged into the emitted Access-Control-Allow-Headers like the headers
+// returned by AllowHeadersFunc.
+const RouteAllowHeaders = "github.com/gin-contrib/cors/route-allow-headers"
+
+// ErrHeadersWritten is attached to the context with c.Error when the response
+// was already written before the middleware could set the CORS headers.
+var ErrHeadersWritten = errors.New("cors: response already written, CORS headers not sent")
+
+// PolicySummary describes a compiled configuration for logging or auditing.
+type PolicySummary struct {
+	AllowAllOrigins     bool
+	AllowCredentials    bool
+	AllowPrivateNetwork bool
+	AllowLocalhost      bool
+	HasOriginFunc       bool
+	Origins             int
+	WildcardOrigins     int
+	RegexpOrigins       int
+	AllowMethods        int
+	AllowHeaders        int
+	ExposeHeaders       int
+	MaxAge              time.Duration
 }
 
 // AddAllowMethods is allowed to add custom methods
@@ -86,6 +513,17 @@ func (c *Config) AddExposeHeaders(headers ...string) {
 	c.ExposeHeaders = append(c.ExposeHeaders, headers...)
 }
 
+func (c Config) getMaxAge() time.Duration {
+	maxAge := c.MaxAge
+	if c.MaxAgeSeconds != 0 {
+		maxAge = time.Duration(c.MaxAgeSeconds) * time.Second
+	}
+	if maxAge == 0 {
+		maxAge = c.DefaultMaxAge
+	}
+	return maxAge
+}
+
 func (c Config) getAllowedSchemas() []string {
 	allowedSchemas := DefaultSchemas
 	if c.AllowBrowserExtensions {
@@ -103,6 +541,21 @@ func (c Config) getAllowedSchemas() []string {
 	return allowedSchemas
 }
 
+// AcceptedSchemes returns the distinct schemes, such as "http", that
+// AllowOrigins entries may use with the current flags and CustomSchemas.
+func (c Config) AcceptedSchemes() []string {
+	var schemes []string
+	seen := make(map[string]bool)
+	for _, schema := range c.getAllowedSchemas() {
+		scheme := strings.TrimSuffix(strings.TrimSpace(schema), "://")
+		if scheme != "" && !seen[scheme] {
+			seen[scheme] = true
+			schemes = append(schemes, scheme)
+		}
+	}
+	return schemes
+}
+
 func (c Config) validateAllowedSchemas(origin string) bool {
 	allowedSchemas := c.getAllowedSchemas()
 	for _, schema := range allowedSchemas {
@@ -115,63 +568,598 @@ func (c Config) validateAllowedSchemas(origin string) bool {
 
 // Validate is check configuration of user defined.
 func (c Config) Validate() error {
-	hasOriginFn := c.AllowOriginFunc != nil
-	hasOriginFn = hasOriginFn || c.AllowOriginWithContextFunc != nil
+	c = c.withOriginsCSV()
+	for _, validate := range []func() error{
+		c.validateOriginSources,
+		c.validateRequireHTTPS,
+		c.validateExactMatchOnly,
+		c.validateStrict,
+		c.validateLimits,
+		c.validateMethods,
+		c.validateHeaders,
+		c.validateResponses,
+		c.validateAllowOrigins,
+		c.validateOriginsByMethod,
+		c.validateChromeExtensionIDs,
+		c.validateSecFetchSite,
+		c.validateOriginGlobs,
+		c.validateWildcards,
+		c.validateServerHostnames,
+		c.validateOriginURLs,
+	} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateOriginSources checks that origins are either all allowed or allowed
+// by at least one origin setting.
+func (c Config) validateOriginSources() error {
+	hasOriginFn := c.AllowOriginFunc != nil || len(c.AllowOriginFuncs) > 0
+	hasOriginFn = hasOriginFn || c.AllowOriginWithContextFunc != nil || c.AllowOriginTokenFunc != nil
+	hasOriginFn = hasOriginFn || c.AllowOriginByClientCert != nil || c.AllowOriginByResolvedIP != nil
 
-	if c.AllowAllOrigins && (hasOriginFn || len(c.AllowOrigins) > 0) {
+	hasOrigins := len(c.AllowOrigins) > 0 || len(c.OriginsByMethod) > 0 || c.OriginStore != nil ||
+		len(c.AllowOriginURLs) > 0 || len(c.AllowOriginGlobs) > 0 ||
+		len(c.AllowChromeExtensionIDs) > 0 || c.AllowLocalhost || c.AllowSameOrigin
+
+	if c.AllowAllOrigins && (hasOriginFn || hasOrigins) {
 		originFields := strings.Join([]string{
 			"AllowOriginFunc",
+			"AllowOriginFuncs",
 			"AllowOriginFuncWithContext",
+			"AllowOriginTokenFunc",
+			"AllowOriginByClientCert",
+			"AllowOriginByResolvedIP",
 			"AllowOrigins",
+			"OriginsByMethod",
+			"OriginStore",
+			"AllowOriginURLs",
+			"AllowOriginGlobs",
+			"AllowChromeExtensionIDs",
+			"AllowLocalhost",
+			"AllowSameOrigin",
 		}, " or ")
 		return fmt.Errorf(
 			"conflict settings: all origins enabled. %s is not needed",
 			originFields,
 		)
 	}
-	if !c.AllowAllOrigins && !hasOriginFn && len(c.AllowOrigins) == 0 {
+	if len(c.AllowOrigins) > 1 {
+		for _, origin := range c.AllowOrigins {
+			if strings.TrimSpace(origin) == "*" {
+				return errors.New(`conflict settings: AllowOrigins mixes "*" with other origins, use "*" alone`)
+			}
+		}
+	}
+	if !c.AllowAllOrigins && !hasOriginFn && !hasOrigins {
 		return errors.New("conflict settings: all origins disabled")
 	}
+	for _, fn := range c.AllowOriginFuncs {
+		if fn == nil {
+			return errors.New("bad AllowOriginFuncs: funcs must not be nil")
+		}
+	}
+	return nil
+}
+
+// validateLimits checks the numeric limits and durations.
+func (c Config) validateLimits() error {
+	if c.MaxOrigins < 0 {
+		return errors.New("bad MaxOrigins: must not be negative")
+	}
+	if n := len(normalize(c.AllowOrigins)); c.MaxOrigins > 0 && n > c.MaxOrigins {
+		return fmt.Errorf("too many origins: %d AllowOrigins entries exceed MaxOrigins %d", n, c.MaxOrigins)
+	}
+	if c.DefaultMaxAge < 0 {
+		return errors.New("bad DefaultMaxAge: must not be negative")
+	}
+	if c.IdempotentMaxAge < 0 {
+		return errors.New("bad IdempotentMaxAge: must not be negative")
+	}
+	if c.ResolvedIPCacheTTL < 0 {
+		return errors.New("bad ResolvedIPCacheTTL: must not be negative")
+	}
+	if c.MaxRequestHeaders < 0 {
+		return errors.New("bad MaxRequestHeaders: must not be negative")
+	}
+	if c.MaxAllowHeadersBytes < 0 {
+		return errors.New("bad MaxAllowHeadersBytes: must not be negative")
+	}
+	if c.MaxAllowHeadersBytes > 0 {
+		if n := len(strings.Join(normalize(c.allowHeaderList()), c.listSeparator())); n > c.MaxAllowHeadersBytes {
+			return fmt.Errorf("bad MaxAllowHeadersBytes: AllowHeaders is %d bytes long", n)
+		}
+	}
+	return nil
+}
+
+// validateMethods checks that the configured methods are HTTP tokens.
+func (c Config) validateMethods() error {
+	methods := append(c.AllowMethods[:len(c.AllowMethods):len(c.AllowMethods)], c.AdvertisedMethods...)
+	for _, method := range normalize(append(methods, c.NoPreflightMethods...)) {
+		if !isToken(method) {
+			return errors.New("bad method " + strconv.Quote(method) + ": methods must be HTTP tokens")
+		}
+	}
+	return nil
+}
+
+// validateHeaders checks the allowed and exposed header names.
+func (c Config) validateHeaders() error {
+	if c.ExposeHeadersFunc != nil && len(c.ExposeHeaders) > 0 {
+		return errors.New("conflict settings: ExposeHeadersFunc can not be used with ExposeHeaders")
+	}
+	for _, header := range normalize(append(c.AllowHeaders[:len(c.AllowHeaders):len(c.AllowHeaders)],
+		c.ExposeHeaders...)) {
+		if !isToken(header) {
+			return errors.New("bad header " + strconv.Quote(header) + ": header names must be HTTP tokens")
+		}
+	}
+	return nil
+}
+
+// validateResponses checks the settings of preflight and denial responses.
+func (c Config) validateResponses() error {
+	if c.PreflightBody != "" && c.OptionsResponseStatusCode == http.StatusNoContent {
+		return errors.New("conflict settings: PreflightBody can not be sent with status 204")
+	}
+	for key := range c.PreflightResponseHeaders {
+		if strings.HasPrefix(http.CanonicalHeaderKey(key), "Access-Control-") {
+			return errors.New("bad PreflightResponseHeaders: " + key + " is a CORS header")
+		}
+	}
+	if c.DenyResponseJSON != nil {
+		if _, err := json.Marshal(c.DenyResponseJSON); err != nil {
+			return fmt.Errorf("bad DenyResponseJSON: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c Config) validateAllowOrigins() error {
 	for _, origin := range c.AllowOrigins {
+		if isRegexpOrigin(origin) {
+			if _, err := compileRegexpOrigin(origin); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, _, err := parsePortRangeOrigin(origin); err != nil {
+			return err
+		}
 		if !strings.Contains(origin, "*") && !c.validateAllowedSchemas(origin) {
-			return errors.New("bad origin: origins must contain '*' or include " + strings.Join(c.getAllowedSchemas(), ","))
+			return errors.New("bad origin " + origin + ": origins must contain '*' or include " +
+				strings.Join(c.getAllowedSchemas(), ","))
 		}
 	}
 	return nil
 }
 
-func (c Config) parseWildcardRules() [][]string {
-	var wRules [][]string
+func (c Config) validateOriginsByMethod() error {
+	for method, origins := range c.OriginsByMethod {
+		for _, origin := range origins {
+			if origin != "*" && !c.validateAllowedSchemas(origin) {
+				return errors.New("bad origin " + origin + " for method " + method + ": origins must include " +
+					strings.Join(c.getAllowedSchemas(), ","))
+			}
+		}
+	}
+	return nil
+}
+
+func (c Config) validateChromeExtensionIDs() error {
+	if len(c.AllowChromeExtensionIDs) > 0 && !c.AllowBrowserExtensions {
+		return errors.New("conflict settings: AllowChromeExtensionIDs requires AllowBrowserExtensions")
+	}
+	for _, id := range c.AllowChromeExtensionIDs {
+		if strings.TrimSpace(id) == "" || strings.ContainsAny(id, "/:*") {
+			return errors.New("bad chrome extension id: " + id)
+		}
+	}
+	return nil
+}
+
+func (c Config) validateSecFetchSite() error {
+	for _, site := range c.RequireSecFetchSite {
+		switch strings.ToLower(strings.TrimSpace(site)) {
+		case "same-origin", "same-site", "cross-site", "none":
+		default:
+			return errors.New("bad RequireSecFetchSite value: " + site)
+		}
+	}
+	return nil
+}
+
+func (c Config) validateOriginGlobs() error {
+	for _, pattern := range c.AllowOriginGlobs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.New("bad origin glob: " + pattern)
+		}
+	}
+	return nil
+}
+
+// validateWildcards checks the wildcard rules of AllowOrigins and their
+// scoping to WildcardApexes.
+func (c Config) validateWildcards() error {
+	rules, err := c.WildcardRules()
+	if err != nil {
+		return err
+	}
+	if len(c.WildcardApexes) > 0 {
+		return validateWildcardApexes(c.WildcardApexes, rules)
+	}
+	return nil
+}
+
+func (c Config) validateServerHostnames() error {
+	if c.DenyServerHostnameOrigins && len(c.ServerHostnames) == 0 {
+		return errors.New("conflict settings: DenyServerHostnameOrigins requires ServerHostnames")
+	}
+	for _, hostname := range c.ServerHostnames {
+		if hostname = strings.TrimSpace(hostname); hostname == "" || strings.ContainsAny(hostname, "*/:") {
+			return errors.New("bad server hostname: " + hostname)
+		}
+	}
+	return nil
+}
+
+func (c Config) validateOriginURLs() error {
+	for _, u := range c.AllowOriginURLs {
+		if u == nil || u.Scheme == "" || u.Host == "" {
+			return errors.New("bad origin URL: scheme and host are required")
+		}
+		if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return errors.New("bad origin URL: " + u.String() + " must not contain a path, query or fragment")
+		}
+		if !c.validateAllowedSchemas(originFromURL(u)) {
+			return errors.New("bad origin URL " + u.String() + ": scheme must be one of " +
+				strings.Join(c.getAllowedSchemas(), ","))
+		}
+	}
+	return nil
+}
+
+// allowOriginStar reports whether responses allow all origins with
+// Access-Control-Allow-Origin: *, which browsers reject for credentialed
+// requests, so the origin is reflected instead when credentials are allowed.
+func (c Config) allowOriginStar() bool {
+	return c.AllowAllOrigins && !(c.AllowCredentials && c.AllowCredentialsFunc == nil)
+}
+
+func isHTTPOrigin(origin string) bool {
+	return len(origin) >= len("http://") && strings.EqualFold(origin[:len("http://")], "http://")
+}
+
+func (c Config) validateRequireHTTPS() error {
+	if !c.RequireHTTPS {
+		return nil
+	}
+	origins := append([]string(nil), c.AllowOrigins...)
+	origins = append(origins, c.AllowOriginGlobs...)
+	for _, u := range c.AllowOriginURLs {
+		if u != nil {
+			origins = append(origins, u.Scheme+"://"+u.Host)
+		}
+	}
+	for _, methodOrigins := range c.OriginsByMethod {
+		origins = append(origins, methodOrigins...)
+	}
+	for _, origin := range origins {
+		if isHTTPOrigin(strings.TrimSpace(origin)) {
+			return errors.New("bad origin " + origin + ": RequireHTTPS does not allow http:// origins")
+		}
+	}
+	return nil
+}
+
+func (c Config) validateExactMatchOnly() error {
+	if !c.ExactMatchOnly {
+		return nil
+	}
+	var fields []string
+	if c.AllowWildcard {
+		fields = append(fields, "AllowWildcard")
+	}
+	if c.WildcardMatchesApex {
+		fields = append(fields, "WildcardMatchesApex")
+	}
+	if c.IgnoreWWWPrefix {
+		fields = append(fields, "IgnoreWWWPrefix")
+	}
+	if len(c.AllowOriginGlobs) > 0 {
+		fields = append(fields, "AllowOriginGlobs")
+	}
+	if c.AllowLocalhost {
+		fields = append(fields, "AllowLocalhost")
+	}
+	if c.AllowSameOrigin {
+		fields = append(fields, "AllowSameOrigin")
+	}
+	if len(fields) > 0 {
+		return errors.New("conflict settings: ExactMatchOnly can not be used with " + strings.Join(fields, ", "))
+	}
+	for _, origin := range c.AllowOrigins {
+		if isRegexpOrigin(origin) {
+			return errors.New("conflict settings: ExactMatchOnly can not be used with regular expression origin " + origin)
+		}
+		if _, ok, _ := parsePortRangeOrigin(origin); ok {
+			return errors.New("conflict settings: ExactMatchOnly can not be used with port range origin " + origin)
+		}
+	}
+	return nil
+}
+
+func (c Config) validateStrict() error {
+	if !c.StrictMode {
+		return nil
+	}
+	if c.MinimalHeaders {
+		return errors.New("strict mode: MinimalHeaders can not be used")
+	}
+	if c.AllowCredentials {
+		if c.AllowAllOrigins {
+			return errors.New("strict mode: AllowAllOrigins can not be used with AllowCredentials")
+		}
+		for _, origin := range c.AllowOrigins {
+			if origin == "*" {
+				return errors.New("strict mode: origin '*' can not be used with AllowCredentials")
+			}
+		}
+	}
+	if c.OptionsResponseStatusCode != 0 && c.OptionsResponseStatusCode != http.StatusNoContent {
+		return errors.New("strict mode: OptionsResponseStatusCode must be 204")
+	}
+	if c.PreflightBody != "" {
+		return errors.New("strict mode: PreflightBody is not allowed")
+	}
+	return nil
+}
+
+// maxBrowserMaxAge is the highest Access-Control-Max-Age honored by Chromium
+// based browsers; larger values are silently capped.
+const maxBrowserMaxAge = 2 * time.Hour
+
+// PreflightHeaderBytes returns the headers written to every successful
+// preflight response, serialized in wire format ("Key: value\r\n" lines
+// sorted by key), or nil if the config is invalid. Headers depending on the
+// request, such as a reflected Access-Control-Allow-Origin, are not included.
+func (c Config) PreflightHeaderBytes() []byte {
+	c.OnCompile = nil
+	cors, err := compileCors(c)
+	if err != nil {
+		return nil
+	}
+	header := make(http.Header, len(cors.preflightHeaders))
+	for _, entry := range cors.preflightHeaders {
+		header[entry.key] = entry.values
+	}
+	var buf bytes.Buffer
+	_ = header.Write(&buf)
+	return buf.Bytes()
+}
+
+// MarshalJSON encodes the config for display, e.g. on an admin endpoint:
+// keys are the field names in lower camel case, funcs and interfaces such as
+// OriginStore are true when set, and durations are in seconds.
+func (c Config) MarshalJSON() ([]byte, error) {
+	v := reflect.ValueOf(c)
+	fields := make(map[string]any, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		fields[strings.ToLower(name[:1])+name[1:]] = jsonValue(v.Field(i))
+	}
+	return json.Marshal(fields)
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	urlsType     = reflect.TypeOf([]*url.URL(nil))
+)
+
+func jsonValue(v reflect.Value) any {
+	switch {
+	case v.Type() == durationType:
+		return int64(time.Duration(v.Int()) / time.Second)
+	case v.Kind() == reflect.Func || v.Kind() == reflect.Interface && v.Type().NumMethod() > 0:
+		return !v.IsNil()
+	case v.Kind() == reflect.Map && v.Type().Elem() == durationType:
+		if v.IsNil() {
+			return nil
+		}
+		seconds := make(map[string]int64, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			seconds[iter.Key().String()] = int64(time.Duration(iter.Value().Int()) / time.Second)
+		}
+		return seconds
+	case v.Type() == urlsType:
+		urls := make([]any, 0, v.Len())
+		for _, u := range v.Interface().([]*url.URL) {
+			if u == nil {
+				// rejected by Validate, still displayed
+				urls = append(urls, nil)
+				continue
+			}
+			urls = append(urls, u.String())
+		}
+		return urls
+	}
+	return v.Interface()
+}
+
+// Warnings returns advisory notes about settings that are valid but likely
+// not to behave as expected. Unlike Validate it never fails.
+func (c Config) Warnings() []string {
+	var warnings []string
+	if maxAge := c.getMaxAge(); maxAge > maxBrowserMaxAge {
+		warnings = append(warnings, fmt.Sprintf(
+			"MaxAge %s exceeds %d seconds, the cap applied by Chrome; browsers will cache preflights for less",
+			maxAge, int64(maxBrowserMaxAge/time.Second),
+		))
+	}
+	if c.IdempotentMaxAge > maxBrowserMaxAge {
+		warnings = append(warnings, fmt.Sprintf(
+			"IdempotentMaxAge %s exceeds %d seconds, the cap applied by Chrome; browsers will cache preflights for less",
+			c.IdempotentMaxAge, int64(maxBrowserMaxAge/time.Second),
+		))
+	}
+	if c.AllowAllOrigins && c.AllowCredentials && c.AllowCredentialsFunc == nil {
+		warnings = append(warnings,
+			"AllowAllOrigins is set with AllowCredentials; every origin is reflected and can make credentialed requests")
+	}
+	if !c.SkipRequestHeaderValidation && !c.AllowExtraRequestedHeaders {
+		warnings = append(warnings, c.allowHeadersWarnings()...)
+	}
+	return warnings
+}
+
+// allowHeadersWarnings reports headers commonly needed by the configured
+// methods or credentials that are missing from AllowHeaders.
+func (c Config) allowHeadersWarnings() []string {
+	allowHeaders := c.allowHeaderList()
+	allowed := stringSet(normalize(allowHeaders))
+	if allowed["*"] {
+		return nil
+	}
+	var warnings []string
+	if len(allowHeaders) > 0 && !allowed["content-type"] {
+		for _, method := range normalize(c.AllowMethods) {
+			if method == "post" || method == "put" || method == "patch" {
+				warnings = append(warnings, "AllowHeaders omits Content-Type while AllowMethods includes "+
+					strings.ToUpper(method)+"; requests sending JSON will fail their preflight")
+				break
+			}
+		}
+	}
+	if c.AllowCredentials && !allowed["authorization"] {
+		warnings = append(warnings,
+			"AllowHeaders omits Authorization while AllowCredentials is set; requests sending it will fail their preflight")
+	}
+	return warnings
+}
+
+// WildcardRule is a parsed AllowOrigins entry containing a single '*',
+// optionally followed by a ":*" port wildcard kept at the end of Suffix.
+// A Prefix or Suffix of "*" means that side of the origin is unrestricted.
+type WildcardRule struct {
+	Prefix string
+	Suffix string
+}
+
+// WildcardRules returns the wildcard rules parsed from AllowOrigins.
+// It returns an error instead of panicking when an origin is invalid.
+func (c Config) WildcardRules() ([]WildcardRule, error) {
+	var rules []WildcardRule
 
 	if !c.AllowWildcard {
-		return wRules
+		return rules, nil
 	}
 
 	for _, o := range c.AllowOrigins {
-		if !strings.Contains(o, "*") {
+		if !strings.Contains(o, "*") || isRegexpOrigin(o) {
 			continue
 		}
 
-		if c := strings.Count(o, "*"); c > 1 {
-			panic(errors.New("only one * is allowed").Error())
+		stars := strings.Count(o, "*")
+		if stars == 2 && strings.HasSuffix(o, ":*") && !strings.HasSuffix(o, "*:*") {
+			i := strings.Index(o, "*")
+			rules = append(rules, WildcardRule{Prefix: o[:i], Suffix: o[i+1:]})
+			continue
+		}
+		if stars > 1 {
+			return nil, errors.New("bad origin " + o + ": only one * is allowed")
 		}
 
 		i := strings.Index(o, "*")
 		if i == 0 {
-			wRules = append(wRules, []string{"*", o[1:]})
+			rules = append(rules, WildcardRule{Prefix: "*", Suffix: o[1:]})
 			continue
 		}
 		if i == (len(o) - 1) {
-			wRules = append(wRules, []string{o[:i], "*"})
+			rules = append(rules, WildcardRule{Prefix: o[:i], Suffix: "*"})
 			continue
 		}
 
-		wRules = append(wRules, []string{o[:i], o[i+1:]})
+		rules = append(rules, WildcardRule{Prefix: o[:i], Suffix: o[i+1:]})
+	}
+
+	return rules, nil
+}
+
+// validateWildcardApexes checks that every wildcard rule only matches
+// subdomains of one of apexes.
+func validateWildcardApexes(apexes []string, rules []WildcardRule) error {
+	for _, apex := range apexes {
+		if apex = strings.TrimSpace(apex); apex == "" || strings.ContainsAny(apex, "*/:") {
+			return errors.New("bad wildcard apex: " + apex)
+		}
+	}
+	for _, rule := range rules {
+		host := rule.Suffix
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		if rule.Suffix == "*" || !strings.HasPrefix(host, ".") || strings.Contains(host, "/") ||
+			!isWithinApexes(host[1:], apexes, true) {
+			return errors.New("bad origin " + rule.Prefix + "*" + rule.Suffix +
+				": wildcard must be restricted to a subdomain of " + strings.Join(apexes, ","))
+		}
+	}
+	return nil
+}
+
+func (c Config) parseWildcardRules() [][]string {
+	var wRules [][]string
+
+	rules, err := c.WildcardRules()
+	if err != nil {
+		panic("cors: " + err.Error())
+	}
+
+	for _, r := range rules {
+		wRules = append(wRules, []string{r.Prefix, r.Suffix})
 	}
 
 	return wRules
 }
 
+// listSeparator returns the separator of the values of list headers.
+func (c Config) listSeparator() string {
+	if c.LegacyHeaderFormat {
+		return ", "
+	}
+	return ","
+}
+
+// withOriginsCSV returns c with AllowOrigins set from AllowOriginsCSV when
+// it is empty.
+func (c Config) withOriginsCSV() Config {
+	if len(c.AllowOrigins) > 0 || c.AllowOriginsCSV == "" {
+		return c
+	}
+	for _, origin := range strings.Split(c.AllowOriginsCSV, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			c.AllowOrigins = append(c.AllowOrigins, origin)
+		}
+	}
+	return c
+}
+
+// Validate checks config like New does, without building the middleware.
+func Validate(config Config) error {
+	return config.Validate()
+}
+
+// MustValidate is like Validate but panics if config is invalid.
+func MustValidate(config Config) {
+	if err := config.Validate(); err != nil {
+		panic("cors: " + err.Error())
+	}
+}
+
 // DefaultConfig returns a generic default configuration mapped to localhost.
 func DefaultConfig() Config {
 	return Config{
@@ -196,3 +1184,110 @@ func New(config Config) gin.HandlerFunc {
 		cors.applyCors(c)
 	}
 }
+
+// PreflightHeaders returns a func setting the CORS headers of a preflight
+// request from an allowed origin, without answering nor aborting it, for
+// OPTIONS handlers used with ManualPreflight. It reports whether the headers
+// were set. A preflight from an allowed origin failing the Sec-Fetch-Site or
+// requested headers checks is denied as by New, aborting the request.
+func PreflightHeaders(config Config) func(c *gin.Context) bool {
+	config.ManualPreflight = false
+	cors := newCors(config)
+	return func(c *gin.Context) bool {
+		if c.Request.Method != http.MethodOptions {
+			return false
+		}
+		origin, ok := requestOrigin(c)
+		if !ok {
+			return false
+		}
+		if valid, err := cors.checkOrigin(c, origin); err != nil || !valid {
+			return false
+		}
+		if cors.deniedSecFetchSite(c, origin) {
+			return false
+		}
+		extraHeaders, ok := cors.checkPreflight(c, origin)
+		if !ok {
+			return false
+		}
+		cors.handlePreflight(c, origin, extraHeaders)
+		cors.applyAllowOrigin(c, origin)
+		if cors.afterApply != nil {
+			cors.afterApply(c, true)
+		}
+		return true
+	}
+}
+
+// NewByPath returns the location middleware applying the configuration of the
+// longest path prefix matching the request path. Prefixes match whole path
+// segments, so "/api" and "/api/*" both match "/api" and "/api/users" but not
+// "/apis", and it panics when two keys are the same prefix. Requests matching
+// no prefix are not handled.
+func NewByPath(rules map[string]Config) gin.HandlerFunc {
+	type pathRule struct {
+		prefix string
+		cors   *cors
+	}
+	pathRules := make([]pathRule, 0, len(rules))
+	keys := make(map[string]string, len(rules))
+	for key, config := range rules {
+		prefix := strings.TrimSuffix(strings.TrimSuffix(key, "*"), "/")
+		if other, ok := keys[prefix]; ok {
+			panic("cors: path rules " + strconv.Quote(other) + " and " + strconv.Quote(key) + " are the same prefix")
+		}
+		keys[prefix] = key
+		pathRules = append(pathRules, pathRule{prefix: prefix, cors: newCors(config)})
+	}
+	sort.SliceStable(pathRules, func(i, j int) bool {
+		if len(pathRules[i].prefix) != len(pathRules[j].prefix) {
+			return len(pathRules[i].prefix) > len(pathRules[j].prefix)
+		}
+		return pathRules[i].prefix < pathRules[j].prefix
+	})
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		for _, rule := range pathRules {
+			if rule.prefix == "" || path == rule.prefix || strings.HasPrefix(path, rule.prefix+"/") {
+				rule.cors.applyCors(c)
+				return
+			}
+		}
+	}
+}
+
+// NewLayered returns the location middleware evaluating several configurations
+// in order. The first configuration allowing the origin handles the request with
+// its own headers; when none allows it the first configuration denies it.
+// ManualPreflight, LogNewOrigins, TreatEmptyOriginAsCORS and FallbackToHostOrigin
+// are taken from the first configuration.
+func NewLayered(configs ...Config) gin.HandlerFunc {
+	if len(configs) == 0 {
+		panic("cors: at least one config is required")
+	}
+	layers := make([]*cors, 0, len(configs))
+	for _, config := range configs {
+		layers = append(layers, newCors(config))
+	}
+	return func(c *gin.Context) {
+		origin, ok := layers[0].originToCheck(c)
+		if !ok {
+			return
+		}
+		for _, layer := range layers {
+			valid, err := layer.checkOrigin(c, origin)
+			if err != nil {
+				layer.originFuncPanicked(c, origin, err)
+				return
+			}
+			if valid {
+				layer.applyAllowed(c, origin)
+				return
+			}
+		}
+		if !layers[0].denyOrReport(c, http.StatusForbidden, origin, DenialOriginNotAllowed) {
+			layers[0].applyAllowed(c, origin)
+		}
+	}
+}
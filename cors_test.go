@@ -1,10 +1,21 @@
 package cors
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -74,6 +85,15 @@ func performRequestWithHeaders(
 	return w
 }
 
+func performRequestWithTLS(r http.Handler, origin string, state *tls.ConnectionState) *httptest.ResponseRecorder {
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Set("Origin", origin)
+	req.TLS = state
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
 func TestConfigAddAllow(t *testing.T) {
 	config := Config{}
 	config.AddAllowMethods("POST")
@@ -644,3 +664,3337 @@ func TestParseWildcardRules(t *testing.T) {
 		})
 	}
 }
+
+func TestPreflightBody(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+	})
+	w := performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Empty(t, w.Header().Get("Content-Type"))
+
+	router = newTestRouter(Config{
+		AllowOrigins:  []string{"http://google.com"},
+		PreflightBody: "ok",
+	})
+	w = performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// denied preflight never gets the body
+	w = performRequest(router, "OPTIONS", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	assert.Panics(t, func() {
+		New(Config{
+			AllowOrigins:              []string{"http://google.com"},
+			OptionsResponseStatusCode: http.StatusNoContent,
+			PreflightBody:             "ok",
+		})
+	})
+}
+
+func TestWildcardRules(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		expected    []WildcardRule
+		expectError bool
+	}{
+		{
+			name: "Wildcard not allowed",
+			config: Config{
+				AllowWildcard: false,
+				AllowOrigins:  []string{"http://example.com", "https://*.domain.com"},
+			},
+			expected: nil,
+		},
+		{
+			name: "No wildcards",
+			config: Config{
+				AllowWildcard: true,
+				AllowOrigins:  []string{"http://example.com", "https://example.com"},
+			},
+			expected: nil,
+		},
+		{
+			name: "Single wildcard at the end",
+			config: Config{
+				AllowWildcard: true,
+				AllowOrigins:  []string{"http://*.example.com"},
+			},
+			expected: []WildcardRule{{Prefix: "http://", Suffix: ".example.com"}},
+		},
+		{
+			name: "Single wildcard at the beginning",
+			config: Config{
+				AllowWildcard: true,
+				AllowOrigins:  []string{"*.example.com"},
+			},
+			expected: []WildcardRule{{Prefix: "*", Suffix: ".example.com"}},
+		},
+		{
+			name: "Single wildcard in the middle",
+			config: Config{
+				AllowWildcard: true,
+				AllowOrigins:  []string{"http://example.*.com"},
+			},
+			expected: []WildcardRule{{Prefix: "http://example.", Suffix: ".com"}},
+		},
+		{
+			name: "Multiple wildcards should error",
+			config: Config{
+				AllowWildcard: true,
+				AllowOrigins:  []string{"http://*.*.com"},
+			},
+			expectError: true,
+		},
+		{
+			name: "Single wildcard in the end",
+			config: Config{
+				AllowWildcard: true,
+				AllowOrigins:  []string{"http://example.com/*"},
+			},
+			expected: []WildcardRule{{Prefix: "http://example.com/", Suffix: "*"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.config.WildcardRules()
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, result)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestEnforceMethodOnActualRequest(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins:                 []string{"http://google.com"},
+		AllowMethods:                 []string{"get", "POST"},
+		AllowCredentials:             true,
+		EnforceMethodOnActualRequest: true,
+	})
+
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "get", w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	w = performRequest(router, "PATCH", "http://google.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// same-origin requests are not checked
+	h := http.Header{}
+	h.Set("Host", "google.com")
+	w = performRequestWithHeaders(router, "PATCH", "/", "http://google.com", h)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// without credentials the method is not enforced
+	router = newTestRouter(Config{
+		AllowOrigins:                 []string{"http://google.com"},
+		AllowMethods:                 []string{"GET"},
+		EnforceMethodOnActualRequest: true,
+	})
+	w = performRequest(router, "PATCH", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAllowOriginURLs(t *testing.T) {
+	mustParse := func(raw string) *url.URL {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	router := newTestRouter(Config{
+		AllowOriginURLs: []*url.URL{
+			mustParse("https://Google.com:443/"),
+			mustParse("http://github.com:80"),
+			mustParse("http://localhost:8080"),
+		},
+	})
+
+	w := performRequest(router, "GET", "https://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "http://github.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(router, "GET", "http://localhost:8080")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(router, "GET", "http://localhost")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Equal(t, "http://[::1]:8080", originFromURL(mustParse("http://[::1]:8080")))
+	assert.Equal(t, "wss://example.com", originFromURL(mustParse("WSS://example.com:443")))
+
+	assert.Error(t, Config{AllowOriginURLs: []*url.URL{mustParse("https://google.com/path")}}.Validate())
+	assert.Error(t, Config{AllowOriginURLs: []*url.URL{mustParse("https://google.com?q=1")}}.Validate())
+	assert.Error(t, Config{AllowOriginURLs: []*url.URL{mustParse("google.com")}}.Validate())
+	assert.Error(t, Config{AllowOriginURLs: []*url.URL{nil}}.Validate())
+	assert.Error(t, Config{
+		AllowAllOrigins: true,
+		AllowOriginURLs: []*url.URL{mustParse("https://google.com")},
+	}.Validate())
+}
+
+func TestAtomicConfigSetWithGrace(t *testing.T) {
+	now := time.Now()
+	a := NewAtomicConfig(Config{
+		AllowOrigins: []string{"http://google.com", "http://github.com"},
+	})
+	a.now = func() time.Time { return now }
+
+	router := gin.New()
+	router.Use(a.Handler())
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "get")
+	})
+
+	w := performRequest(router, "GET", "http://github.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	a.SetWithGrace(Config{
+		AllowOrigins: []string{"http://google.com"},
+	}, []string{"http://github.com"}, time.Minute)
+
+	// grace origin is still allowed during the window
+	w = performRequest(router, "GET", "http://github.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "http://github.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// and denied once the window has elapsed
+	now = now.Add(time.Minute + time.Second)
+	w = performRequest(router, "GET", "http://github.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	a.Set(Config{AllowOrigins: []string{"http://example.com"}})
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// grace origins are normalized like AllowOrigins
+	a.SetWithGrace(Config{
+		AllowOrigins: []string{"http://example.com"},
+	}, []string{"HTTPS://Old.com:443", "http://[0:0:0:0:0:0:0:1]:8080"}, time.Minute)
+	for _, origin := range []string{"https://old.com", "http://[::1]:8080"} {
+		w = performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusOK, w.Code, origin)
+	}
+
+	// and kept byte for byte with ExactMatchOnly
+	a.SetWithGrace(Config{
+		AllowOrigins:   []string{"http://example.com"},
+		ExactMatchOnly: true,
+	}, []string{"https://old.com:443"}, time.Minute)
+	w = performRequest(router, "GET", "https://old.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, "GET", "https://old.com:443")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestExposeHeadersFunc(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://dashboard.example.com", "http://public.example.com", "http://other.example.com"},
+		ExposeHeadersFunc: func(origin string) []string {
+			switch origin {
+			case "http://dashboard.example.com":
+				return []string{"X-Request-Id", "x-debug-trace"}
+			case "http://public.example.com":
+				return []string{"X-Request-Id"}
+			}
+			return nil
+		},
+	}
+	router := newTestRouter(config)
+
+	w := performRequest(router, "GET", "http://dashboard.example.com")
+	assert.Equal(t, "X-Request-Id,X-Debug-Trace", w.Header().Get("Access-Control-Expose-Headers"))
+
+	w = performRequest(router, "GET", "http://public.example.com")
+	assert.Equal(t, "X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+
+	w = performRequest(router, "GET", "http://other.example.com")
+	assert.Empty(t, w.Header().Get("Access-Control-Expose-Headers"))
+
+	config.ExposeHeaders = []string{"X-Request-Id"}
+	assert.EqualError(t, config.Validate(),
+		"conflict settings: ExposeHeadersFunc can not be used with ExposeHeaders")
+}
+
+func TestBarePreflight(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowMethods: []string{"GET", "POST"},
+	})
+
+	// OPTIONS without Access-Control-Request-Method is answered like any preflight
+	w := performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET,POST", w.Header().Get("Access-Control-Allow-Methods"))
+
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "POST")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET,POST", w.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestRegexpOrigins(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins: []string{
+			`/https://[a-z]+\.example\.com/i`,
+			`/http://localhost:\d+/g`,
+		},
+		AllowWildcard: true,
+	})
+
+	w := performRequest(router, "GET", "https://api.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://api.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "HTTPS://Api.Example.COM")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HTTPS://Api.Example.COM", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "https://api.example.com.evil.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "GET", "http://localhost:3000")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(router, "GET", "HTTP://LOCALHOST:3000")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Error(t, Config{AllowOrigins: []string{`/https://example\.com/x`}}.Validate())
+	assert.Error(t, Config{AllowOrigins: []string{`/https://(example\.com/i`}}.Validate())
+	assert.NoError(t, Config{AllowOrigins: []string{`/https://example\.com/ism`}}.Validate())
+}
+
+func TestHandlePreflightMatchesGeneratedHeaders(t *testing.T) {
+	config := Config{
+		AllowOrigins:        []string{"http://google.com"},
+		AllowMethods:        []string{"GET", "POST", "PUT"},
+		AllowHeaders:        []string{"Content-Type", "X-Requested-With", "Authorization"},
+		AllowCredentials:    true,
+		AllowPrivateNetwork: true,
+		MaxAge:              12 * time.Hour,
+	}
+	router := newTestRouter(config)
+	w := performRequest(router, "OPTIONS", "http://google.com")
+
+	expected := generatePreflightHeaders(config)
+	expected.Set("Access-Control-Allow-Origin", "http://google.com")
+	assert.Equal(t, expected, w.Header())
+}
+
+func BenchmarkPreflight(b *testing.B) {
+	gin.SetMode(gin.ReleaseMode)
+	router := newTestRouter(Config{
+		AllowOrigins:     []string{"http://google.com"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	})
+	req, _ := http.NewRequestWithContext(context.Background(), "OPTIONS", "/", nil)
+	req.Header.Set("Origin", "http://google.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkNormal(b *testing.B) {
+	gin.SetMode(gin.ReleaseMode)
+	router := newTestRouter(Config{
+		AllowOrigins:  []string{"http://google.com"},
+		ExposeHeaders: []string{"Content-Length", "X-Request-Id"},
+	})
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Set("Origin", "http://google.com")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestAllowLocalhost(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowLocalhost: true,
+	})
+
+	for _, origin := range []string{
+		"http://localhost",
+		"http://localhost:3000",
+		"https://127.0.0.1:8443",
+		"http://127.0.0.1",
+		"http://[::1]:8080",
+		"http://0.0.0.0:5173",
+	} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusOK, w.Code, origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+
+	for _, origin := range []string{
+		"http://example.com",
+		"http://localhost.example.com",
+		"http://192.168.1.1:3000",
+		"ws://localhost:3000",
+		"http://localhost:3000/path",
+	} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+}
+
+func TestNewDynamic(t *testing.T) {
+	var errs []error
+	router := gin.New()
+	router.Use(NewDynamic(DynamicConfig{
+		Provider: func(c *gin.Context) Config {
+			if c.Query("tenant") == "broken" {
+				return Config{AllowAllOrigins: true, AllowOrigins: []string{"http://google.com"}}
+			}
+			return Config{AllowOrigins: []string{"http://google.com"}}
+		},
+		ErrorStatusCode: http.StatusInternalServerError,
+		OnError: func(c *gin.Context, err error) {
+			errs = append(errs, err)
+		},
+	}))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "get")
+	})
+
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, errs)
+
+	assert.NotPanics(t, func() {
+		w = performRequestWithHeaders(router, "GET", "/?tenant=broken", "http://google.com", http.Header{})
+	})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Len(t, errs, 1)
+
+	router = gin.New()
+	router.Use(NewDynamic(DynamicConfig{
+		Provider: func(c *gin.Context) Config {
+			return Config{AllowWildcard: true, AllowOrigins: []string{"http://*.*.com"}}
+		},
+	}))
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Panics(t, func() { NewDynamic(DynamicConfig{}) })
+}
+
+func TestIncludeSimpleHeaders(t *testing.T) {
+	config := Config{
+		AllowOrigins:         []string{"http://google.com"},
+		AllowHeaders:         []string{"X-Custom", "content-type"},
+		IncludeSimpleHeaders: true,
+	}
+	router := newTestRouter(config)
+
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "POST")
+	h.Set("Access-Control-Request-Headers", "Accept, X-Custom")
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t,
+		"X-Custom,Content-Type,Accept,Accept-Language,Content-Language",
+		w.Header().Get("Access-Control-Allow-Headers"),
+	)
+	assert.Equal(t, []string{"X-Custom", "content-type"}, config.AllowHeaders)
+
+	header := generatePreflightHeaders(Config{IncludeSimpleHeaders: true})
+	assert.Equal(t, "Accept,Accept-Language,Content-Language,Content-Type", header.Get("Access-Control-Allow-Headers"))
+
+	header = generatePreflightHeaders(Config{AllowHeaders: []string{"X-Custom"}})
+	assert.Equal(t, "X-Custom", header.Get("Access-Control-Allow-Headers"))
+}
+
+func TestSkipRequestHeaderValidation(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowHeaders: []string{"Content-Type"},
+	}
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "POST")
+	h.Set("Access-Control-Request-Headers", "content-type, dnt,x-odd-header")
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.NotContains(t, w.Header().Get("Access-Control-Allow-Headers"), "Dnt")
+
+	config.SkipRequestHeaderValidation = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type,Dnt,X-Odd-Header", w.Header().Get("Access-Control-Allow-Headers"))
+
+	// without requested headers the configured ones are kept
+	w = performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+
+	router = newTestRouter(Config{
+		AllowAllOrigins:             true,
+		SkipRequestHeaderValidation: true,
+	})
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "Content-Type,Dnt,X-Odd-Header", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "Access-Control-Request-Headers", w.Header().Get("Vary"))
+
+	// the "*" header is reflected too, not denied
+	h.Set("Access-Control-Request-Headers", "*")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestOnCompile(t *testing.T) {
+	var summaries []PolicySummary
+	config := Config{
+		AllowOrigins: []string{
+			"http://google.com",
+			"https://*.github.com",
+			`/https://[a-z]+\.example\.com/`,
+		},
+		AllowOriginURLs:  []*url.URL{{Scheme: "https", Host: "facebook.com"}},
+		AllowMethods:     []string{"GET", "post", "POST"},
+		AllowHeaders:     []string{"Content-Type"},
+		ExposeHeaders:    []string{"X-Request-Id", "X-Trace"},
+		AllowCredentials: true,
+		AllowWildcard:    true,
+		MaxAge:           time.Hour,
+		OnCompile: func(summary PolicySummary) {
+			summaries = append(summaries, summary)
+		},
+	}
+
+	router := newTestRouter(config)
+	performRequest(router, "GET", "http://google.com")
+	performRequest(router, "OPTIONS", "http://google.com")
+
+	assert.Equal(t, []PolicySummary{{
+		AllowCredentials: true,
+		Origins:          2,
+		WildcardOrigins:  1,
+		RegexpOrigins:    1,
+		AllowMethods:     2,
+		AllowHeaders:     1,
+		ExposeHeaders:    2,
+		MaxAge:           time.Hour,
+	}}, summaries)
+
+	summaries = nil
+	assert.Panics(t, func() {
+		New(Config{AllowAllOrigins: true, AllowOrigins: []string{"http://google.com"}, OnCompile: config.OnCompile})
+	})
+	assert.Empty(t, summaries)
+}
+
+func TestWildcardMatchesApex(t *testing.T) {
+	config := Config{
+		AllowOrigins:  []string{"https://*.example.com", "*.golang.org"},
+		AllowWildcard: true,
+	}
+
+	router := newTestRouter(config)
+	w := performRequest(router, "GET", "https://api.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", "https://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, "GET", "https://golang.org")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	config.WildcardMatchesApex = true
+	router = newTestRouter(config)
+	w = performRequest(router, "GET", "https://api.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", "https://example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "http://golang.org")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, origin := range []string{
+		"https://notexample.com",
+		"http://example.com",
+		"https://example.com.evil.com",
+		"https://notgolang.org",
+	} {
+		w = performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+}
+
+func TestDenyResponseJSON(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+		DenyResponseJSON: map[string]string{
+			"error": "origin not allowed",
+		},
+	})
+
+	w := performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"origin not allowed"}`, w.Body.String())
+
+	w = performRequest(router, "OPTIONS", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"origin not allowed"}`, w.Body.String())
+
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "get", w.Body.String())
+
+	assert.Error(t, Config{
+		AllowOrigins:     []string{"http://google.com"},
+		DenyResponseJSON: func() {},
+	}.Validate())
+}
+
+func TestPassNonPreflightOptions(t *testing.T) {
+	config := Config{
+		AllowOrigins:  []string{"http://google.com"},
+		AllowMethods:  []string{"GET", "OPTIONS"},
+		ExposeHeaders: []string{"Allow"},
+	}
+	newRouter := func(config Config) *gin.Engine {
+		router := newTestRouter(config)
+		router.OPTIONS("/", func(c *gin.Context) {
+			c.Header("Allow", "GET,OPTIONS")
+			c.String(http.StatusOK, "options")
+		})
+		return router
+	}
+	preflight := http.Header{}
+	preflight.Set("Access-Control-Request-Method", "GET")
+
+	// by default every OPTIONS request is handled as a preflight
+	router := newRouter(config)
+	w := performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	config.PassNonPreflightOptions = true
+	router = newRouter(config)
+
+	w = performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "options", w.Body.String())
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Allow", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", preflight)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, "GET,OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestPreflightResponseHeaders(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowMethods: []string{"GET", "POST"},
+		MaxAge:       time.Hour,
+		PreflightResponseHeaders: http.Header{
+			"Cdn-Cache-Control": []string{"max-age=3600"},
+			"vary":              []string{"Accept-Encoding"},
+		},
+	})
+
+	w := performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET,POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, "max-age=3600", w.Header().Get("CDN-Cache-Control"))
+	assert.Equal(t, []string{
+		"Origin",
+		"Access-Control-Request-Method",
+		"Access-Control-Request-Headers",
+		"Accept-Encoding",
+	}, w.Header().Values("Vary"))
+
+	// not added to actual or denied requests
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Empty(t, w.Header().Get("CDN-Cache-Control"))
+	w = performRequest(router, "OPTIONS", "http://example.com")
+	assert.Empty(t, w.Header().Get("CDN-Cache-Control"))
+
+	assert.Error(t, Config{
+		AllowOrigins:             []string{"http://google.com"},
+		PreflightResponseHeaders: http.Header{"access-control-max-age": []string{"1"}},
+	}.Validate())
+}
+
+func TestStrictMode(t *testing.T) {
+	config := Config{
+		AllowAllOrigins:             true,
+		AllowMethods:                []string{"GET", "POST"},
+		AllowPrivateNetwork:         true,
+		SkipRequestHeaderValidation: true,
+	}
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "POST")
+	h.Add("Access-Control-Request-Headers", "x-one")
+	h.Add("Access-Control-Request-Headers", "x-two")
+
+	// non-strict preflight
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-One", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Private-Network"))
+	assert.Equal(t, []string{"Access-Control-Request-Headers"}, w.Header().Values("Vary"))
+
+	// strict preflight
+	config.StrictMode = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-One,X-Two", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Private-Network"))
+	assert.Equal(t, []string{
+		"Access-Control-Request-Method",
+		"Access-Control-Request-Headers",
+	}, w.Header().Values("Vary"))
+
+	pna := h.Clone()
+	pna.Set("Access-Control-Request-Private-Network", "true")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", pna)
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Private-Network"))
+
+	// strict validation
+	assert.Error(t, Config{AllowAllOrigins: true, AllowCredentials: true, StrictMode: true}.Validate())
+	assert.Error(t, Config{AllowOrigins: []string{"*"}, AllowCredentials: true, StrictMode: true}.Validate())
+	assert.Error(t, Config{AllowAllOrigins: true, OptionsResponseStatusCode: http.StatusOK, StrictMode: true}.Validate())
+	assert.Error(t, Config{AllowAllOrigins: true, PreflightBody: "ok", StrictMode: true}.Validate())
+	assert.NoError(t, Config{AllowAllOrigins: true, AllowCredentials: true}.Validate())
+}
+
+func TestAllowOriginGlobs(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOriginGlobs: []string{
+			"https://*.example.com",
+			"https://app-?.example.org",
+			"http://localhost:300[0-9]",
+		},
+	})
+
+	for _, origin := range []string{
+		"https://api.example.com",
+		"https://a.b.example.com",
+		"HTTPS://API.Example.com",
+		"https://app-1.example.org",
+		"https://app-b.example.org",
+		"http://localhost:3005",
+	} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusOK, w.Code, origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+
+	for _, origin := range []string{
+		"https://example.com",
+		"http://api.example.com",
+		"https://app-10.example.org",
+		"https://app-.example.org",
+		"http://localhost:3010",
+		"https://api.example.com.evil.com",
+	} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+
+	assert.Error(t, Config{AllowOriginGlobs: []string{"https://[a-.example.com"}}.Validate())
+	assert.Error(t, Config{AllowAllOrigins: true, AllowOriginGlobs: []string{"https://*.example.com"}}.Validate())
+}
+
+func TestDenialFromContext(t *testing.T) {
+	type result struct {
+		origin, reason string
+		ok             bool
+	}
+	var got result
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		got.origin, got.reason, got.ok = DenialFromContext(c)
+	})
+	router.Use(New(Config{
+		AllowOrigins:                 []string{"http://google.com"},
+		AllowMethods:                 []string{"GET"},
+		AllowCredentials:             true,
+		EnforceMethodOnActualRequest: true,
+	}))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "get")
+	})
+	router.POST("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "post")
+	})
+
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, result{}, got)
+
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, result{"http://example.com", DenialOriginNotAllowed, true}, got)
+
+	w = performRequest(router, "POST", "http://google.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, result{"http://google.com", DenialMethodNotAllowed, true}, got)
+}
+
+func TestMaxAgeByOrigin(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"https://internal.example.com", "https://public.example.com", "https://other.example.com"},
+		MaxAge:       5 * time.Minute,
+		MaxAgeByOrigin: map[string]time.Duration{
+			"https://Internal.example.com": 24 * time.Hour,
+			"https://other.example.com":    0,
+		},
+	})
+
+	w := performRequest(router, "OPTIONS", "https://internal.example.com")
+	assert.Equal(t, "86400", w.Header().Get("Access-Control-Max-Age"))
+
+	w = performRequest(router, "OPTIONS", "https://public.example.com")
+	assert.Equal(t, "300", w.Header().Get("Access-Control-Max-Age"))
+
+	w = performRequest(router, "OPTIONS", "https://other.example.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Max-Age"))
+
+	// the precomputed headers are not modified by overrides
+	w = performRequest(router, "OPTIONS", "https://public.example.com")
+	assert.Equal(t, "300", w.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestIgnoreWWWPrefix(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"https://example.com", "https://www.example.org"},
+	}
+
+	router := newTestRouter(config)
+	w := performRequest(router, "GET", "https://www.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	config.IgnoreWWWPrefix = true
+	router = newTestRouter(config)
+	for _, origin := range []string{
+		"https://example.com",
+		"https://www.example.com",
+		"https://example.org",
+		"https://www.example.org",
+	} {
+		w = performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusOK, w.Code, origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+
+	for _, origin := range []string{
+		"http://www.example.com",
+		"https://wwwexample.com",
+		"https://api.example.com",
+		"https://www.www.example.com",
+	} {
+		w = performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+}
+
+func TestNewLayered(t *testing.T) {
+	router := gin.New()
+	router.Use(NewLayered(
+		Config{
+			AllowOrigins:  []string{"https://app.example.com"},
+			AllowMethods:  []string{"GET"},
+			ExposeHeaders: []string{"X-Base"},
+		},
+		Config{
+			AllowOrigins:     []string{"https://plugin.example.com", "https://app.example.com"},
+			AllowMethods:     []string{"GET", "POST"},
+			ExposeHeaders:    []string{"X-Plugin"},
+			AllowCredentials: true,
+		},
+	))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "get")
+	})
+
+	// first config wins when both allow the origin
+	w := performRequest(router, "GET", "https://app.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "X-Base", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// second config allows an origin the first denies
+	w = performRequest(router, "GET", "https://plugin.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://plugin.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Plugin", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	w = performRequest(router, "OPTIONS", "https://plugin.example.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET,POST", w.Header().Get("Access-Control-Allow-Methods"))
+
+	w = performRequest(router, "GET", "https://evil.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "GET", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Panics(t, func() { NewLayered() })
+}
+
+func TestNewLayeredRequestOptions(t *testing.T) {
+	var seen []string
+	router := gin.New()
+	router.Use(NewLayered(
+		Config{
+			AllowOrigins:    []string{"https://app.example.com"},
+			ManualPreflight: true,
+			LogNewOrigins:   func(origin string) { seen = append(seen, origin) },
+		},
+		Config{
+			AllowOrigins: []string{"https://plugin.example.com"},
+		},
+	))
+	router.OPTIONS("/", func(c *gin.Context) {
+		c.String(299, "handler")
+	})
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "get")
+	})
+
+	// the preflight is left to the route, as with New
+	w := performRequest(router, "OPTIONS", "https://plugin.example.com")
+	assert.Equal(t, 299, w.Code)
+	assert.Equal(t, "handler", w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "https://plugin.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://plugin.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"https://plugin.example.com"}, seen)
+}
+
+func TestReportOnly(t *testing.T) {
+	type report struct{ origin, reason string }
+	var reports []report
+	config := Config{
+		AllowOrigins: []string{"http://google.com"},
+		ReportFunc: func(origin, reason string) {
+			reports = append(reports, report{origin, reason})
+		},
+	}
+
+	// reports are emitted for enforced denials too
+	router := newTestRouter(config)
+	w := performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, []report{{"http://example.com", DenialOriginNotAllowed}}, reports)
+
+	reports = nil
+	config.ReportOnly = true
+	router = newTestRouter(config)
+
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "get", w.Body.String())
+	assert.Equal(t, "http://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "OPTIONS", "http://example.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, []report{
+		{"http://example.com", DenialOriginNotAllowed},
+		{"http://example.com", DenialOriginNotAllowed},
+	}, reports)
+}
+
+func TestRequireSecFetchSite(t *testing.T) {
+	config := Config{
+		AllowOrigins:        []string{"https://app.example.com"},
+		RequireSecFetchSite: []string{"same-origin", "Same-Site"},
+	}
+	site := func(value string) http.Header {
+		h := http.Header{}
+		if value != "" {
+			h.Set("Sec-Fetch-Site", value)
+		}
+		return h
+	}
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "GET", "/", "https://app.example.com", site("same-site"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequestWithHeaders(router, "GET", "/", "https://app.example.com", site("cross-site"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "https://app.example.com", site("cross-site"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// missing header fails by default
+	w = performRequestWithHeaders(router, "GET", "/", "https://app.example.com", site(""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	config.AllowMissingSecFetchSite = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "GET", "/", "https://app.example.com", site(""))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the origin list still applies
+	w = performRequestWithHeaders(router, "GET", "/", "https://evil.example.com", site("same-site"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Error(t, Config{
+		AllowOrigins:        []string{"https://app.example.com"},
+		RequireSecFetchSite: []string{"same-host"},
+	}.Validate())
+}
+
+func TestPreserveHeaderCase(t *testing.T) {
+	config := Config{
+		AllowOrigins:  []string{"http://google.com"},
+		AllowHeaders:  []string{"X-API-Key", " x-api-key", "Content-Type"},
+		ExposeHeaders: []string{"X-RateLimit-Limit", "X-RateLimit-Remaining ", "x-ratelimit-limit"},
+	}
+
+	router := newTestRouter(config)
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "X-Ratelimit-Limit,X-Ratelimit-Remaining", w.Header().Get("Access-Control-Expose-Headers"))
+	w = performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, "X-Api-Key,Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+
+	config.PreserveHeaderCase = true
+	router = newTestRouter(config)
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "X-RateLimit-Limit,X-RateLimit-Remaining", w.Header().Get("Access-Control-Expose-Headers"))
+	w = performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, "X-API-Key,Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+
+	config.ExposeHeaders = nil
+	config.ExposeHeadersFunc = func(origin string) []string {
+		return []string{"X-RateLimit-Reset"}
+	}
+	router = newTestRouter(config)
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "X-RateLimit-Reset", w.Header().Get("Access-Control-Expose-Headers"))
+}
+
+func TestNewPanicMessage(t *testing.T) {
+	assert.PanicsWithValue(t,
+		"cors: bad origin google.com: origins must contain '*' or include http://,https://",
+		func() {
+			New(Config{AllowOrigins: []string{"http://example.com", "google.com"}})
+		},
+	)
+	assert.PanicsWithValue(t,
+		"cors: bad origin http://*.*.com: only one * is allowed",
+		func() {
+			New(Config{AllowOrigins: []string{"http://*.*.com"}, AllowWildcard: true})
+		},
+	)
+	assert.PanicsWithValue(t, "cors: conflict settings: all origins disabled", func() {
+		New(Config{})
+	})
+}
+
+func TestAllowSameOrigin(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"https://app.example.com"},
+	}
+	host := func(value string) http.Header {
+		h := http.Header{}
+		h.Set("Host", value)
+		return h
+	}
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "POST", "/", "https://api.example.com", host("API.example.com:443"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	config.AllowSameOrigin = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "POST", "/", "https://api.example.com", host("API.example.com:443"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "post", w.Body.String())
+
+	w = performRequestWithHeaders(router, "POST", "/", "http://api.example.com:8080", host("api.example.com:8080"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// other hosts and ports are still subject to the list
+	w = performRequestWithHeaders(router, "POST", "/", "https://api.example.com:8443", host("api.example.com"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestWithHeaders(router, "POST", "/", "https://evil.example.com", host("api.example.com"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestWithHeaders(router, "POST", "/", "https://app.example.com", host("api.example.com"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.NoError(t, Config{AllowSameOrigin: true}.Validate())
+}
+
+func TestAllowChromeExtensionIDs(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins:            []string{"https://app.example.com"},
+		AllowBrowserExtensions:  true,
+		AllowChromeExtensionIDs: []string{"abcdefghijklmnopabcdefghijklmnop", " ponmlkjihgfedcbaponmlkjihgfedcba "},
+	})
+
+	w := performRequest(router, "GET", "chrome-extension://abcdefghijklmnopabcdefghijklmnop")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "chrome-extension://abcdefghijklmnopabcdefghijklmnop", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "chrome-extension://ponmlkjihgfedcbaponmlkjihgfedcba")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(router, "GET", "chrome-extension://unlisted")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "GET", "moz-extension://abcdefghijklmnopabcdefghijklmnop")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "GET", "https://app.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Error(t, Config{AllowChromeExtensionIDs: []string{"abcdefghijklmnop"}}.Validate())
+	assert.Error(t, Config{
+		AllowBrowserExtensions:  true,
+		AllowChromeExtensionIDs: []string{"chrome-extension://abcdefghijklmnop"},
+	}.Validate())
+	assert.NoError(t, Config{
+		AllowBrowserExtensions:  true,
+		AllowChromeExtensionIDs: []string{"abcdefghijklmnop"},
+	}.Validate())
+}
+
+func TestDefaultPortOrigins(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins: []string{
+			"https://example.com:443",
+			"http://example.org",
+			"http://example.net:8080",
+			"wss://socket.example.com:443",
+		},
+		AllowWebSockets: true,
+	})
+
+	for _, origin := range []string{
+		"https://example.com",
+		"https://example.com:443",
+		"http://example.org",
+		"http://example.org:80",
+		"http://example.net:8080",
+		"wss://socket.example.com",
+	} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusOK, w.Code, origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+
+	for _, origin := range []string{
+		"https://example.com:8443",
+		"http://example.com",
+		"http://example.org:8080",
+		"https://example.org:80",
+		"http://example.net",
+		"http://example.net:80",
+	} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+
+	assert.Equal(t, "https://example.com", stripDefaultPort("https://example.com:443"))
+	assert.Equal(t, "https://[::1]", stripDefaultPort("https://[::1]:443"))
+	assert.Equal(t, "https://example.com:4443", stripDefaultPort("https://example.com:4443"))
+	assert.Equal(t, "https://:443", stripDefaultPort("https://:443"))
+	assert.Equal(t, "tauri://localhost:443", stripDefaultPort("tauri://localhost:443"))
+}
+
+func TestLogNewOrigins(t *testing.T) {
+	var logged []string
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+		LogNewOrigins: func(origin string) {
+			logged = append(logged, origin)
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		performRequest(router, "GET", "http://google.com")
+		performRequest(router, "OPTIONS", "http://google.com")
+		performRequest(router, "GET", "http://example.com")
+		performRequest(router, "GET", "")
+	}
+	assert.Equal(t, []string{"http://google.com", "http://example.com"}, logged)
+
+	logged = nil
+	set := &originSet{seen: make(map[string]struct{}), max: 2, fn: func(origin string) {
+		logged = append(logged, origin)
+	}}
+	set.observe("a")
+	set.observe("b")
+	set.observe("a")
+	set.observe("c")
+	set.observe("a")
+	assert.Equal(t, []string{"a", "b", "c", "a"}, logged)
+}
+
+func TestAdvertisedMethods(t *testing.T) {
+	config := Config{
+		AllowOrigins:                 []string{"http://google.com"},
+		AllowMethods:                 []string{"GET", "POST"},
+		AdvertisedMethods:            []string{"get", "post", "delete"},
+		AllowCredentials:             true,
+		EnforceMethodOnActualRequest: true,
+	}
+	router := newTestRouter(config)
+	router.DELETE("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "delete")
+	})
+
+	w := performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET,POST,DELETE", w.Header().Get("Access-Control-Allow-Methods"))
+
+	w = performRequest(router, "DELETE", "http://google.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "POST", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	config.AdvertisedMethods = nil
+	header := generatePreflightHeaders(config)
+	assert.Equal(t, "GET,POST", header.Get("Access-Control-Allow-Methods"))
+}
+
+func TestOriginFuncPanic(t *testing.T) {
+	var errs []error
+	var allowed map[string]bool
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(New(Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowOriginWithContextFunc: func(c *gin.Context, origin string) bool {
+			allowed[origin] = true // nil map
+			return true
+		},
+		OriginFuncPanicStatusCode: http.StatusBadRequest,
+		OnOriginFuncPanic: func(c *gin.Context, err error) {
+			errs = append(errs, err)
+		},
+	}))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "get")
+	})
+
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, errs)
+
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "cors: origin func panicked")
+
+	router = newTestRouter(Config{
+		AllowOriginFunc: func(origin string) bool {
+			panic("boom")
+		},
+	})
+	assert.NotPanics(t, func() {
+		w = performRequest(router, "OPTIONS", "http://example.com")
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestOriginsByMethod(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"https://app.example.com"},
+		OriginsByMethod: map[string][]string{
+			"get":    {"*"},
+			"DELETE": {"https://admin.example.com"},
+		},
+	})
+	router.DELETE("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "delete")
+	})
+	preflight := func(method string) http.Header {
+		h := http.Header{}
+		h.Set("Access-Control-Request-Method", method)
+		return h
+	}
+
+	w := performRequest(router, "GET", "https://public.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://public.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "DELETE", "https://public.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "https://public.example.com", preflight("DELETE"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "DELETE", "https://admin.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "https://admin.example.com", preflight("DELETE"))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = performRequest(router, "POST", "https://admin.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// global origins apply to every method
+	w = performRequest(router, "DELETE", "https://app.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Error(t, Config{OriginsByMethod: map[string][]string{"GET": {"example.com"}}}.Validate())
+	assert.NoError(t, Config{OriginsByMethod: map[string][]string{"GET": {"*"}}}.Validate())
+}
+
+func TestDebugMode(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://google.com"},
+	}
+
+	router := newTestRouter(config)
+	w := performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("X-CORS-Attempted-Origin"))
+	assert.Empty(t, w.Header().Get("X-CORS-Denial-Reason"))
+
+	config.DebugMode = true
+	router = newTestRouter(config)
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "http://example.com", w.Header().Get("X-CORS-Attempted-Origin"))
+	assert.Equal(t, DenialOriginNotAllowed, w.Header().Get("X-CORS-Denial-Reason"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "OPTIONS", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "http://example.com", w.Header().Get("X-CORS-Attempted-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-CORS-Attempted-Origin"))
+}
+
+func TestMaxAgeSeconds(t *testing.T) {
+	header := generatePreflightHeaders(Config{
+		MaxAgeSeconds: 600,
+	})
+	assert.Equal(t, "600", header.Get("Access-Control-Max-Age"))
+
+	header = generatePreflightHeaders(Config{
+		MaxAge:        12 * time.Hour,
+		MaxAgeSeconds: 600,
+	})
+	assert.Equal(t, "600", header.Get("Access-Control-Max-Age"))
+
+	header = generatePreflightHeaders(Config{
+		MaxAge: 12 * time.Hour,
+	})
+	assert.Equal(t, "43200", header.Get("Access-Control-Max-Age"))
+
+	header = generatePreflightHeaders(Config{
+		MaxAge:        12 * time.Hour,
+		MaxAgeSeconds: -1,
+	})
+	assert.Empty(t, header.Get("Access-Control-Max-Age"))
+
+	var config Config
+	err := json.Unmarshal([]byte(`{"AllowOrigins":["http://google.com"],"MaxAgeSeconds":300}`), &config)
+	assert.NoError(t, err)
+	router := newTestRouter(config)
+	w := performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, "300", w.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestExactMatchOnly(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"https://Example.com", "https://example.org:443"},
+		OriginsByMethod: map[string][]string{
+			"GET": {"https://example.net"},
+		},
+		ExactMatchOnly: true,
+	}
+	router := newTestRouter(config)
+
+	w := performRequest(router, "GET", "https://Example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", "https://example.org:443")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", "https://example.net")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, origin := range []string{
+		"https://example.com",
+		"https://Example.com:443",
+		"https://example.org",
+		"https://example.net:443",
+	} {
+		w = performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+
+	// the same near misses are allowed without the flag
+	config.ExactMatchOnly = false
+	router = newTestRouter(config)
+	w = performRequest(router, "GET", "https://example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", "https://example.org")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Error(t, Config{
+		AllowOrigins:   []string{"https://*.example.com"},
+		AllowWildcard:  true,
+		ExactMatchOnly: true,
+	}.Validate())
+	assert.Error(t, Config{
+		AllowOrigins:   []string{`/https://example\.com/`},
+		ExactMatchOnly: true,
+	}.Validate())
+	assert.Error(t, Config{
+		AllowOrigins:   []string{"http://localhost:3000-3005"},
+		ExactMatchOnly: true,
+	}.Validate())
+	assert.Error(t, Config{AllowLocalhost: true, ExactMatchOnly: true}.Validate())
+}
+
+func TestAllowCredentialsFunc(t *testing.T) {
+	allowCredentials := func(origin string) bool {
+		return origin == "https://trusted.example.com"
+	}
+	router := newTestRouter(Config{
+		AllowOrigins:         []string{"https://trusted.example.com", "https://public.example.com"},
+		AllowCredentials:     true,
+		AllowCredentialsFunc: allowCredentials,
+	})
+
+	w := performRequest(router, "GET", "https://trusted.example.com")
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "https://trusted.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "https://public.example.com")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "https://public.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "OPTIONS", "https://trusted.example.com")
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	w = performRequest(router, "OPTIONS", "https://public.example.com")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// credentialed origins are reflected instead of "*"
+	router = newTestRouter(Config{
+		AllowAllOrigins:      true,
+		AllowCredentialsFunc: allowCredentials,
+		StrictMode:           true,
+	})
+	w = performRequest(router, "GET", "https://trusted.example.com")
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "https://trusted.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	w = performRequest(router, "GET", "https://public.example.com")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "OPTIONS", "https://trusted.example.com")
+	assert.Equal(t, "https://trusted.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{
+		"Access-Control-Request-Method",
+		"Access-Control-Request-Headers",
+		"Origin",
+	}, w.Header().Values("Vary"))
+
+	w = performRequest(router, "OPTIONS", "https://public.example.com")
+	assert.Equal(t, []string{
+		"Access-Control-Request-Method",
+		"Access-Control-Request-Headers",
+		"Origin",
+	}, w.Header().Values("Vary"))
+}
+
+type fakeOriginStore struct {
+	origins []string
+}
+
+func (s *fakeOriginStore) Origins() []string {
+	return s.origins
+}
+
+func TestOriginStore(t *testing.T) {
+	store := &fakeOriginStore{origins: []string{"https://one.example.com"}}
+	router := newTestRouter(Config{
+		OriginStore: store,
+	})
+
+	w := performRequest(router, "GET", "https://one.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://one.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "https://two.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	store.origins = []string{" https://Two.example.com:443 "}
+	w = performRequest(router, "GET", "https://one.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, "GET", "https://two.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "OPTIONS", "https://two.example.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	store.origins = nil
+	w = performRequest(router, "GET", "https://two.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.NoError(t, Config{OriginStore: store}.Validate())
+	assert.Error(t, Config{AllowAllOrigins: true, OriginStore: store}.Validate())
+}
+
+func TestWarningsMaxAge(t *testing.T) {
+	assert.Empty(t, Config{MaxAge: 2 * time.Hour}.Warnings())
+	assert.Empty(t, Config{MaxAgeSeconds: 7200}.Warnings())
+
+	warnings := DefaultConfig().Warnings()
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "MaxAge 12h0m0s exceeds 7200 seconds")
+
+	assert.Len(t, Config{MaxAgeSeconds: 7201}.Warnings(), 1)
+	assert.Empty(t, Config{MaxAge: 24 * time.Hour, MaxAgeSeconds: 600}.Warnings())
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins:  []string{"https://*.example.com", "https://app.example.org"},
+		AllowWildcard: true,
+		AllowOriginFunc: func(origin string) bool {
+			return true
+		},
+		RequireHTTPS: true,
+	})
+
+	w := performRequest(router, "GET", "https://api.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", "https://other.example.net")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, origin := range []string{
+		"http://api.example.com",
+		"http://app.example.org",
+		"HTTP://other.example.net",
+	} {
+		w = performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+		w = performRequest(router, "OPTIONS", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+
+	assert.Error(t, Config{AllowOrigins: []string{"http://app.example.org"}, RequireHTTPS: true}.Validate())
+	assert.Error(t, Config{AllowOriginGlobs: []string{"http://*.example.org"}, RequireHTTPS: true}.Validate())
+	assert.Error(t, Config{
+		OriginsByMethod: map[string][]string{"GET": {"http://app.example.org"}},
+		RequireHTTPS:    true,
+	}.Validate())
+	assert.Error(t, Config{
+		AllowOriginURLs: []*url.URL{{Scheme: "http", Host: "app.example.org"}},
+		RequireHTTPS:    true,
+	}.Validate())
+	assert.NoError(t, Config{AllowOrigins: []string{"https://app.example.org"}, RequireHTTPS: true}.Validate())
+}
+
+func TestRequestedWildcardHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "POST")
+	h.Set("Access-Control-Request-Headers", "*")
+
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowHeaders: []string{"*"},
+	})
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Headers"))
+
+	router = newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowHeaders: []string{"Content-Type", "X-Custom"},
+	})
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Headers"))
+
+	h.Set("Access-Control-Request-Headers", "x-custom")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestNewByPath(t *testing.T) {
+	router := gin.New()
+	router.Use(NewByPath(map[string]Config{
+		"/api/*": {
+			AllowOrigins: []string{"https://app.example.com"},
+		},
+		"/api/public/": {
+			AllowAllOrigins: true,
+		},
+		"/api/private": {
+			AllowOrigins:     []string{"https://admin.example.com"},
+			AllowCredentials: true,
+		},
+	}))
+	for _, path := range []string{"/api/public/items", "/api/private/items", "/api/other", "/apis", "/health"} {
+		router.GET(path, func(c *gin.Context) {
+			c.String(http.StatusOK, "get")
+		})
+	}
+
+	w := performRequestWithHeaders(router, "GET", "/api/public/items", "https://random.example.com", http.Header{})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequestWithHeaders(router, "GET", "/api/private/items", "https://random.example.com", http.Header{})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestWithHeaders(router, "GET", "/api/private/items", "https://admin.example.com", http.Header{})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	w = performRequestWithHeaders(router, "GET", "/api/other", "https://app.example.com", http.Header{})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequestWithHeaders(router, "GET", "/api/other", "https://admin.example.com", http.Header{})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// paths matching no prefix fall through without CORS handling
+	for _, path := range []string{"/apis", "/health"} {
+		w = performRequestWithHeaders(router, "GET", path, "https://random.example.com", http.Header{})
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), path)
+	}
+
+	assert.Panics(t, func() {
+		NewByPath(map[string]Config{
+			"/api":   {AllowOrigins: []string{"https://a.com"}},
+			"/api/*": {AllowOrigins: []string{"https://b.com"}},
+		})
+	})
+}
+
+func TestAllowExtraRequestedHeaders(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowHeaders: []string{"Content-Type", "X-Custom"},
+	}
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "POST")
+	h.Set("Access-Control-Request-Headers", "x-custom,pragma")
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "Content-Type,X-Custom", w.Header().Get("Access-Control-Allow-Headers"))
+
+	config.AllowExtraRequestedHeaders = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type,X-Custom,Pragma", w.Header().Get("Access-Control-Allow-Headers"))
+
+	// configured headers only when nothing extra is requested
+	w = performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, "Content-Type,X-Custom", w.Header().Get("Access-Control-Allow-Headers"))
+
+	h.Set("Access-Control-Request-Headers", "*")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type,X-Custom,*", w.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestValidateMethodTokens(t *testing.T) {
+	config := Config{
+		AllowAllOrigins: true,
+		AllowMethods:    []string{"GET", "post", "M-SEARCH", "PROPFIND"},
+	}
+	assert.NoError(t, config.Validate())
+
+	for _, method := range []string{"GE T", "G@T", ""} {
+		config.AllowMethods = []string{"GET", method}
+		assert.Error(t, config.Validate(), method)
+	}
+
+	config.AllowMethods = []string{"GET"}
+	config.AdvertisedMethods = []string{"GET", "PU,T"}
+	assert.Error(t, config.Validate())
+}
+
+func TestHeadersAlreadyWritten(t *testing.T) {
+	var buf bytes.Buffer
+	var errs []string
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		errs = c.Errors.Errors()
+	}, func(c *gin.Context) {
+		c.String(http.StatusOK, "early")
+		c.Writer.Flush()
+	}, New(Config{
+		AllowOrigins: []string{"http://google.com"},
+		Logger:       log.New(&buf, "", 0),
+	}))
+	router.GET("/", func(c *gin.Context) {})
+
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "early", w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, buf.String(), "response already written for GET / from origin http://google.com")
+	assert.Equal(t, []string{ErrHeadersWritten.Error()}, errs)
+
+	// nothing is logged for requests handled before any output
+	buf.Reset()
+	router = newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+		Logger:       log.New(&buf, "", 0),
+	})
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, buf.String())
+}
+
+func TestCanonicalizeReflectedOrigin(t *testing.T) {
+	config := Config{
+		AllowOriginFunc: func(origin string) bool {
+			return strings.EqualFold(canonicalOrigin(origin), "https://example.com") ||
+				strings.EqualFold(origin, "https://example.com:8443")
+		},
+	}
+	router := newTestRouter(config)
+	w := performRequest(router, "GET", "HTTPS://Example.COM:443")
+	assert.Equal(t, "HTTPS://Example.COM:443", w.Header().Get("Access-Control-Allow-Origin"))
+
+	config.CanonicalizeReflectedOrigin = true
+	router = newTestRouter(config)
+	w = performRequest(router, "GET", "HTTPS://Example.COM:443")
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "OPTIONS", "HTTPS://Example.COM:443")
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// non default ports are kept
+	w = performRequest(router, "GET", "https://EXAMPLE.com:8443")
+	assert.Equal(t, "https://example.com:8443", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestPackageValidate(t *testing.T) {
+	valid := Config{AllowOrigins: []string{"https://example.com"}}
+	assert.NoError(t, Validate(valid))
+	assert.NotPanics(t, func() { MustValidate(valid) })
+
+	invalid := Config{AllowOrigins: []string{"example.com"}}
+	assert.Equal(t, invalid.Validate(), Validate(invalid))
+	assert.PanicsWithValue(t, "cors: "+invalid.Validate().Error(), func() { MustValidate(invalid) })
+	assert.Error(t, Validate(Config{}))
+}
+
+func TestIPv6Origins(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://[::1]:8080", "https://[2001:DB8:0:0::1]:443"},
+	})
+
+	w := performRequest(router, "GET", "http://[::1]:8080")
+	assert.Equal(t, "http://[::1]:8080", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "http://[::1]")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "GET", "http://[::1]:80")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// default port and non canonical address in the config
+	w = performRequest(router, "GET", "https://[2001:db8::1]")
+	assert.Equal(t, "https://[2001:db8::1]", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "https://[2001:db8::1]:443")
+	assert.Equal(t, "https://[2001:db8::1]:443", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequest(router, "GET", "https://[2001:db8::1]:8443")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Equal(t, "http://[2001:db8::1]:8080", normalizeIPv6Host("http://[2001:DB8:0::1]:8080"))
+	assert.Equal(t, "http://[::ffff:1.2.3.4]", normalizeIPv6Host("http://[::ffff:1.2.3.4]"))
+	assert.Equal(t, "http://example.com", normalizeIPv6Host("http://example.com"))
+}
+
+func TestAfterApply(t *testing.T) {
+	var calls []bool
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+		AfterApply: func(c *gin.Context, allowed bool) {
+			calls = append(calls, allowed)
+			c.Header("X-Policy-Version", "2")
+		},
+	})
+
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "2", w.Header().Get("X-Policy-Version"))
+
+	w = performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-Policy-Version"))
+
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-Policy-Version"))
+
+	// not a CORS request
+	w = performRequest(router, "GET", "")
+	assert.Empty(t, w.Header().Get("X-Policy-Version"))
+
+	assert.Equal(t, []bool{true, true, false}, calls)
+}
+
+func TestMaxRequestHeaders(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins:      []string{"http://google.com"},
+		AllowHeaders:      []string{"X-A", "X-B", "X-C"},
+		MaxRequestHeaders: 2,
+	})
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "GET")
+
+	h.Set("Access-Control-Request-Headers", "x-a, ,x-b,")
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	h.Set("Access-Control-Request-Headers", "x-a,x-b,x-c")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	assert.Error(t, Config{AllowAllOrigins: true, MaxRequestHeaders: -1}.Validate())
+}
+
+func TestEchoMethodIntersection(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowMethods: []string{"GET", "POST", "PUT"},
+	}
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "PUT")
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "GET,POST,PUT", w.Header().Get("Access-Control-Allow-Methods"))
+
+	config.EchoMethodIntersection = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "PUT", w.Header().Get("Access-Control-Allow-Methods"))
+
+	// a method not allowed keeps the full list
+	h.Set("Access-Control-Request-Method", "DELETE")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "GET,POST,PUT", w.Header().Get("Access-Control-Allow-Methods"))
+
+	config.AllowOrigins = nil
+	config.AllowAllOrigins = true
+	router = newTestRouter(config)
+	h.Set("Access-Control-Request-Method", "POST")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, []string{"Access-Control-Request-Method"}, w.Header().Values("Vary"))
+}
+
+func TestNormalizeASCIIOnly(t *testing.T) {
+	// strings.ToLower maps U+212A KELVIN SIGN to "k" and U+0130 LATIN CAPITAL
+	// LETTER I WITH DOT ABOVE to "i\u0307".
+	assert.Equal(t, []string{"x-\u212aey", "x-\u0130d", "x-key"},
+		normalize([]string{"X-\u212aEY", "X-\u0130D", "X-KEY"}))
+	assert.Equal(t, "http://example.com", asciiLower("HTTP://Example.COM"))
+
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://\u212aey.com"},
+	})
+	w := performRequest(router, "GET", "http://key.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, "GET", "http://\u212aey.com")
+	assert.Equal(t, "http://\u212aey.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestWildcardApexes(t *testing.T) {
+	config := Config{
+		AllowWildcard:  true,
+		AllowOrigins:   []string{"https://*.example.com", "http://*.dev.example.org:8080"},
+		WildcardApexes: []string{"example.com", "example.org"},
+	}
+	assert.NoError(t, config.Validate())
+
+	router := newTestRouter(config)
+	w := performRequest(router, "GET", "https://api.example.com")
+	assert.Equal(t, "https://api.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "http://a.dev.example.org:8080")
+	assert.Equal(t, "http://a.dev.example.org:8080", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "https://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	for _, origin := range []string{"https://api.*", "https://*", "*.example.net", "https://*example.com"} {
+		config.AllowOrigins = []string{origin}
+		assert.Error(t, config.Validate(), origin)
+	}
+
+	config.AllowOrigins = []string{"https://*.example.com"}
+	config.WildcardApexes = []string{"*.com"}
+	assert.Error(t, config.Validate())
+}
+
+func TestPreflightHeaderBytes(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowMethods: []string{"GET", "POST"},
+		AllowHeaders: []string{"Content-Type"},
+		MaxAge:       time.Hour,
+	}
+	b := config.PreflightHeaderBytes()
+	assert.True(t, strings.HasSuffix(string(b), "\r\n"))
+
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(b, "\r\n"...))))
+	parsed, err := r.ReadMIMEHeader()
+	assert.NoError(t, err)
+	assert.Equal(t, textproto.MIMEHeader{
+		"Access-Control-Allow-Methods": {"GET,POST"},
+		"Access-Control-Allow-Headers": {"Content-Type"},
+		"Access-Control-Max-Age":       {"3600"},
+		"Vary":                         {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+	}, parsed)
+
+	// matches the headers of a preflight response
+	w := performRequest(newTestRouter(config), "OPTIONS", "http://google.com")
+	for key, values := range parsed {
+		assert.Equal(t, values, w.Header().Values(key), key)
+	}
+
+	assert.Nil(t, Config{}.PreflightHeaderBytes())
+}
+
+func TestPortRangeOrigins(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://localhost:3000-3010", "https://[::1]:8440-8450", "http://example.com:79-81"},
+	}
+	assert.NoError(t, config.Validate())
+
+	router := newTestRouter(config)
+	for _, origin := range []string{
+		"http://localhost:3000", "http://localhost:3005", "http://localhost:3010",
+		"https://[::1]:8443", "http://example.com", "http://example.com:80",
+	} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+	for _, origin := range []string{
+		"http://localhost:2999", "http://localhost:3011", "http://localhost",
+		"https://localhost:3005", "http://localhost:+3005", "http://localhost:3000-3010",
+	} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+
+	for _, origin := range []string{
+		"http://localhost:3010-3000", "http://localhost:0-10", "http://localhost:3000-70000",
+		"http://localhost:3000-", "http://localhost:-3000", "http://localhost:a-b", "http://localhost:1-2-3",
+	} {
+		config.AllowOrigins = []string{origin}
+		assert.Error(t, config.Validate(), origin)
+	}
+
+	// hyphenated hosts are not ranges
+	config.AllowOrigins = []string{"http://my-host.com", "http://my-host.com:8080"}
+	assert.NoError(t, config.Validate())
+}
+
+func TestEmptyMethodsAllowAll(t *testing.T) {
+	config := Config{
+		AllowOrigins:                 []string{"http://google.com"},
+		AllowCredentials:             true,
+		EnforceMethodOnActualRequest: true,
+	}
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "PUT")
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+	w = performRequest(router, "POST", "http://google.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	config.EmptyMethodsAllowAll = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "PUT", w.Header().Get("Access-Control-Allow-Methods"))
+	w = performRequest(router, "POST", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// a non empty list is still enforced
+	config.AllowMethods = []string{"GET"}
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "GET", w.Header().Get("Access-Control-Allow-Methods"))
+	w = performRequest(router, "POST", "http://google.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestDiff(t *testing.T) {
+	a := Config{
+		AllowOrigins: []string{"https://a.example.com", "https://b.example.com"},
+		AllowMethods: []string{"GET"},
+		MaxAge:       time.Hour,
+	}
+	b := a
+	b.AllowOrigins = []string{"https://b.example.com", " https://c.example.com"}
+	b.AllowCredentials = true
+	b.AllowOriginFunc = func(origin string) bool { return false }
+
+	assert.Empty(t, Diff(a, a))
+	assert.Equal(t, []ConfigChange{
+		{Field: "AllowOrigins", Kind: ChangeRemoved, Value: "https://a.example.com"},
+		{Field: "AllowOrigins", Kind: ChangeAdded, Value: "https://c.example.com"},
+		{Field: "AllowOriginFunc", Kind: ChangeChanged, Old: "nil", New: "set"},
+		{Field: "AllowCredentials", Kind: ChangeChanged, Old: "false", New: "true"},
+	}, Diff(a, b))
+
+	// funcs are only compared by nil-ness
+	a.AllowOriginFunc = func(origin string) bool { return true }
+	a.AllowOrigins = b.AllowOrigins
+	a.AllowCredentials = true
+	assert.Empty(t, Diff(a, b))
+}
+
+func TestAllowOriginFuncWithList(t *testing.T) {
+	var called []string
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"http://listed.com"},
+		AllowOriginFunc: func(origin string) bool {
+			called = append(called, origin)
+			return origin == "http://dynamic.com"
+		},
+	})
+
+	w := performRequest(router, "GET", "http://listed.com")
+	assert.Equal(t, "http://listed.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "http://dynamic.com")
+	assert.Equal(t, "http://dynamic.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "http://other.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Equal(t, []string{"http://dynamic.com", "http://other.com"}, called)
+}
+
+func TestCredentialedWildcardPreflight(t *testing.T) {
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "PUT")
+
+	router := newTestRouter(Config{
+		AllowWildcard:    true,
+		AllowOrigins:     []string{"https://*.example.com"},
+		AllowMethods:     []string{"PUT"},
+		AllowCredentials: true,
+	})
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "https://app.example.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// all origins allowed: the origin is reflected since * can not be used with credentials
+	router = newTestRouter(Config{
+		AllowAllOrigins:  true,
+		AllowMethods:     []string{"PUT"},
+		AllowCredentials: true,
+	})
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "https://app.example.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		w.Header().Values("Vary"))
+
+	w = performRequest(router, "GET", "https://app.example.com")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, []string{"Origin"}, w.Header().Values("Vary"))
+}
+
+func TestJoinedHeaderValues(t *testing.T) {
+	config := Config{
+		AllowOrigins:  []string{"http://google.com"},
+		AllowMethods:  []string{"get", " POST", "Put "},
+		AllowHeaders:  []string{"content-type", "X-Custom"},
+		ExposeHeaders: []string{"x-a", "X-B"},
+	}
+	assert.Equal(t, "GET,POST,PUT", generatePreflightHeaders(config).Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type,X-Custom", generatePreflightHeaders(config).Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "X-A,X-B", generateNormalHeaders(config).Get("Access-Control-Expose-Headers"))
+
+	router := newTestRouter(config)
+	w := performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, "GET,POST,PUT", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type,X-Custom", w.Header().Get("Access-Control-Allow-Headers"))
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "X-A,X-B", w.Header().Get("Access-Control-Expose-Headers"))
+}
+
+func TestAllowOriginTokenFunc(t *testing.T) {
+	config := Config{
+		AllowOriginTokenFunc: func(c *gin.Context) (string, bool) {
+			token := c.GetHeader("X-Origin-Token")
+			i := strings.LastIndex(token, ".")
+			if i < 0 || token[i+1:] != "signed" {
+				return "", false
+			}
+			return token[:i], true
+		},
+	}
+	router := newTestRouter(config)
+	send := func(origin, token string) *httptest.ResponseRecorder {
+		h := http.Header{}
+		h.Set("X-Origin-Token", token)
+		return performRequestWithHeaders(router, "GET", "/", origin, h)
+	}
+
+	w := send("https://app.example.com", "https://app.example.com.signed")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = send("https://app.example.com", "HTTPS://APP.example.com:443.signed")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// invalid signature
+	w = send("https://app.example.com", "https://app.example.com.forged")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	// valid token for another origin
+	w = send("https://evil.example.com", "https://app.example.com.signed")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = send("https://app.example.com", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// compared byte for byte with ExactMatchOnly
+	config.ExactMatchOnly = true
+	router = newTestRouter(config)
+	w = send("https://app.example.com", "https://app.example.com.signed")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = send("https://app.example.com", "HTTPS://APP.example.com:443.signed")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAllowOriginValueFunc(t *testing.T) {
+	config := Config{
+		AllowAllOrigins: true,
+		AllowOriginValueFunc: func(origin string) string {
+			return origin
+		},
+	}
+	router := newTestRouter(config)
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"Origin"}, w.Header().Values("Vary"))
+
+	w = performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"Origin"}, w.Header().Values("Vary"))
+
+	// the default behavior is kept without the func
+	config.AllowOriginValueFunc = nil
+	router = newTestRouter(config)
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Values("Vary"))
+
+	router = newTestRouter(Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowOriginValueFunc: func(origin string) string {
+			return ""
+		},
+	})
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"Origin"}, w.Header().Values("Vary"))
+}
+
+func TestDefaultMaxAge(t *testing.T) {
+	maxAge := func(config Config) string {
+		config.AllowOrigins = []string{"http://google.com"}
+		w := performRequest(newTestRouter(config), "OPTIONS", "http://google.com")
+		return w.Header().Get("Access-Control-Max-Age")
+	}
+
+	// default
+	assert.Equal(t, "600", maxAge(Config{DefaultMaxAge: 10 * time.Minute}))
+	assert.Equal(t, "", maxAge(Config{}))
+	// explicit
+	assert.Equal(t, "60", maxAge(Config{MaxAge: time.Minute, DefaultMaxAge: 10 * time.Minute}))
+	assert.Equal(t, "30", maxAge(Config{MaxAgeSeconds: 30, DefaultMaxAge: 10 * time.Minute}))
+	// omitted
+	assert.Equal(t, "", maxAge(Config{MaxAge: -1, DefaultMaxAge: 10 * time.Minute}))
+	assert.Equal(t, "", maxAge(Config{MaxAgeSeconds: -1, DefaultMaxAge: 10 * time.Minute}))
+
+	assert.Error(t, Config{AllowAllOrigins: true, DefaultMaxAge: -time.Second}.Validate())
+}
+
+func TestRequireOriginMatchesSNI(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins:            []string{"https://a.internal", "https://b.internal"},
+		RequireOriginMatchesSNI: true,
+	})
+	w := performRequestWithTLS(router, "https://a.internal", &tls.ConnectionState{ServerName: "A.internal"})
+	assert.Equal(t, "https://a.internal", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = performRequestWithTLS(router, "https://b.internal", &tls.ConnectionState{ServerName: "a.internal"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequestWithTLS(router, "https://a.internal", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestManualPreflight(t *testing.T) {
+	config := Config{
+		AllowOrigins:    []string{"http://google.com"},
+		AllowMethods:    []string{"GET", "PUT"},
+		ManualPreflight: true,
+	}
+	preflightHeaders := PreflightHeaders(config)
+	router := newTestRouter(config)
+	router.OPTIONS("/", func(c *gin.Context) {
+		if preflightHeaders(c) {
+			c.String(http.StatusOK, "allowed")
+			return
+		}
+		c.String(http.StatusOK, "options")
+	})
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "PUT")
+
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "allowed", w.Body.String())
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET,PUT", w.Header().Get("Access-Control-Allow-Methods"))
+
+	// denied origins reach the handler without CORS headers
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://example.com", h.Clone())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "options", w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	// other methods are still handled by the middleware
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestPreflightHeadersChecks(t *testing.T) {
+	var reasons []string
+	config := Config{
+		AllowOrigins:        []string{"http://google.com"},
+		AllowMethods:        []string{"GET", "PUT"},
+		ManualPreflight:     true,
+		RequireSecFetchSite: []string{"same-site"},
+		MaxRequestHeaders:   1,
+		ReportFunc: func(origin, reason string) {
+			reasons = append(reasons, reason)
+		},
+	}
+	preflightHeaders := PreflightHeaders(config)
+	router := newTestRouter(config)
+	router.OPTIONS("/", func(c *gin.Context) {
+		if preflightHeaders(c) {
+			c.String(http.StatusOK, "allowed")
+			return
+		}
+		if !c.IsAborted() {
+			c.String(http.StatusOK, "options")
+		}
+	})
+	preflight := func(site, headers string) *httptest.ResponseRecorder {
+		h := http.Header{}
+		h.Set("Access-Control-Request-Method", "PUT")
+		h.Set("Sec-Fetch-Site", site)
+		h.Set("Access-Control-Request-Headers", headers)
+		return performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h)
+	}
+
+	w := preflight("same-site", "X-One")
+	assert.Equal(t, "allowed", w.Body.String())
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = preflight("cross-site", "X-One")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = preflight("same-site", "X-One, X-Two, X-Three")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = preflight("same-site", "*")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Equal(t, []string{DenialSecFetchSite, DenialTooManyHeaders, DenialHeaderNotAllowed}, reasons)
+}
+
+func TestAllowOriginByClientCert(t *testing.T) {
+	trusted := &x509.Certificate{Raw: []byte("trusted client")}
+	other := &x509.Certificate{Raw: []byte("other client")}
+	fingerprints := map[[32]byte]string{
+		sha256.Sum256(trusted.Raw): "https://app.example.com",
+	}
+	router := newTestRouter(Config{
+		AllowOrigins: []string{"https://static.example.com"},
+		AllowOriginByClientCert: func(origin string, cert *x509.Certificate) bool {
+			return fingerprints[sha256.Sum256(cert.Raw)] == origin
+		},
+	})
+	peer := func(cert *x509.Certificate) *tls.ConnectionState {
+		return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	}
+
+	w := performRequestWithTLS(router, "https://app.example.com", peer(trusted))
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequestWithTLS(router, "https://app.example.com", peer(other))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestWithTLS(router, "https://app.example.com", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestWithTLS(router, "https://evil.example.com", peer(trusted))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// the func augments the static list
+	w = performRequestWithTLS(router, "https://static.example.com", peer(other))
+	assert.Equal(t, "https://static.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCredentialsOnlyWhenPresent(t *testing.T) {
+	for _, config := range []Config{
+		{AllowCredentials: true},
+		{AllowCredentialsFunc: func(origin string) bool { return true }},
+	} {
+		config.AllowOrigins = []string{"http://google.com"}
+		config.CredentialsOnlyWhenPresent = true
+		router := newTestRouter(config)
+
+		w := performRequest(router, "GET", "http://google.com")
+		assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Subset(t, w.Header().Values("Vary"), []string{"Cookie", "Authorization"})
+
+		for _, key := range []string{"Cookie", "Authorization"} {
+			h := http.Header{}
+			h.Set(key, "secret")
+			w = performRequestWithHeaders(router, "GET", "/", "http://google.com", h)
+			assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"), key)
+			assert.Subset(t, w.Header().Values("Vary"), []string{"Cookie", "Authorization"}, key)
+		}
+
+		// preflights never carry credentials
+		w = performRequest(router, "OPTIONS", "http://google.com")
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	}
+}
+
+func TestAcceptedSchemes(t *testing.T) {
+	assert.Equal(t, []string{"http", "https"}, Config{}.AcceptedSchemes())
+	assert.Equal(t, []string{"http", "https", "ws", "wss"}, Config{AllowWebSockets: true}.AcceptedSchemes())
+	assert.Equal(t, []string{"http", "https", "file"}, Config{AllowFiles: true}.AcceptedSchemes())
+	assert.Equal(t,
+		[]string{"http", "https", "chrome-extension", "safari-extension", "moz-extension", "ms-browser-extension", "tauri"},
+		Config{AllowBrowserExtensions: true, CustomSchemas: []string{"tauri://", "https", "tauri"}}.AcceptedSchemes())
+}
+
+func TestMaxOrigins(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"https://a.example.com", "https://b.example.com", "https://A.example.com"},
+		MaxOrigins:   2,
+	}
+	assert.NoError(t, config.Validate())
+
+	config.AllowOrigins = append(config.AllowOrigins, "https://c.example.com")
+	assert.EqualError(t, config.Validate(), "too many origins: 3 AllowOrigins entries exceed MaxOrigins 2")
+
+	config.MaxOrigins = 0
+	assert.NoError(t, config.Validate())
+	config.MaxOrigins = -1
+	assert.Error(t, config.Validate())
+}
+
+func TestOmitAllowHeadersWhenNotRequested(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowHeaders: []string{"Content-Type", "X-Custom"},
+	}
+	router := newTestRouter(config)
+	w := performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, "Content-Type,X-Custom", w.Header().Get("Access-Control-Allow-Headers"))
+
+	config.OmitAllowHeadersWhenNotRequested = true
+	router = newTestRouter(config)
+	w = performRequest(router, "OPTIONS", "http://google.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Values("Access-Control-Allow-Headers"))
+
+	h := http.Header{}
+	h.Set("Access-Control-Request-Headers", "x-custom")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h)
+	assert.Equal(t, "Content-Type,X-Custom", w.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestCredentialsOnActualOnly(t *testing.T) {
+	for _, config := range []Config{
+		{AllowCredentials: true},
+		{AllowCredentialsFunc: func(origin string) bool { return true }},
+	} {
+		config.AllowOrigins = []string{"http://google.com"}
+		config.CredentialsOnActualOnly = true
+		router := newTestRouter(config)
+
+		w := performRequest(router, "OPTIONS", "http://google.com")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Values("Access-Control-Allow-Credentials"))
+
+		w = performRequest(router, "GET", "http://google.com")
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	}
+}
+
+func TestSuffixTrie(t *testing.T) {
+	rules := [][]string{
+		{"https://", ".example.com"},
+		{"*", ".example.org"},
+		{"http://", ".a.b.example.net"},
+		{"https://", ".example.com"},
+	}
+	naive := func(origin string, matchApex bool) bool {
+		for _, w := range rules {
+			if strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) ||
+				w[0] == "*" && strings.HasSuffix(origin, w[1]) ||
+				matchApex && matchesWildcardApex(origin, w) {
+				return true
+			}
+		}
+		return false
+	}
+	trie := &suffixTrie{}
+	for _, w := range rules {
+		assert.True(t, isSuffixRule(w), w)
+		trie.insert(w)
+	}
+	for _, origin := range []string{
+		"https://a.example.com", "https://a.b.example.com", "http://a.example.com", "https://example.com",
+		"https://aexample.com", "https://a.example.com:8443", "https://a.example.comm", "wss://x.example.org",
+		"http://example.org", "http://c.a.b.example.net", "http://b.example.net", "https://c.a.b.example.net",
+		"https://", "example.com", "https://.example.com", "https://a.example.com.evil.com",
+	} {
+		for _, matchApex := range []bool{false, true} {
+			assert.Equal(t, naive(origin, matchApex), trie.match(origin, matchApex), "%s %v", origin, matchApex)
+		}
+	}
+
+	for _, w := range [][]string{
+		{"https://api.", "*"}, {"https://a", ".example.com"}, {"*", "example.com"}, {"*", ".example.com:8080"},
+	} {
+		assert.False(t, isSuffixRule(w), w)
+	}
+
+	router := newTestRouter(Config{
+		AllowWildcard: true,
+		AllowOrigins:  []string{"https://*.example.com", "http://*.example.com:8080", "https://api.*"},
+	})
+	for origin, allowed := range map[string]bool{
+		"https://a.example.com":      true,
+		"http://a.example.com:8080":  true,
+		"https://api.other.com":      true,
+		"http://a.example.com":       false,
+		"https://a.example.com:8443": false,
+	} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, allowed, w.Code == http.StatusOK, origin)
+	}
+}
+
+func BenchmarkSuffixWildcardOrigins(b *testing.B) {
+	origins := make([]string, 0, 50000)
+	for i := 0; i < 50000; i++ {
+		origins = append(origins, "https://*.tenant"+strconv.Itoa(i)+".example.com")
+	}
+	cors := newCors(Config{AllowWildcard: true, AllowOrigins: origins})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !cors.validateOrigin("https://app.tenant49999.example.com") {
+			b.Fatal("origin not allowed")
+		}
+	}
+}
+
+func TestDeniedStatusFunc(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins:                 []string{"http://google.com"},
+		AllowMethods:                 []string{"GET"},
+		AllowCredentials:             true,
+		EnforceMethodOnActualRequest: true,
+		DeniedStatusFunc: func(c *gin.Context, origin, reason string) int {
+			switch reason {
+			case DenialOriginNotAllowed:
+				return http.StatusUnauthorized
+			case DenialMethodNotAllowed:
+				return http.StatusMethodNotAllowed
+			}
+			return 0
+		},
+		MaxRequestHeaders: 1,
+	})
+
+	w := performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performRequest(router, "POST", "http://google.com")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// 0 keeps the default status
+	h := http.Header{}
+	h.Set("Access-Control-Request-Headers", "x-a,x-b")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMinimalHeaders(t *testing.T) {
+	config := Config{
+		AllowAllOrigins:                  true,
+		AllowMethods:                     []string{"GET", "PUT"},
+		AllowHeaders:                     []string{"X-Custom"},
+		EchoMethodIntersection:           true,
+		OmitAllowHeadersWhenNotRequested: true,
+	}
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "PUT")
+	h.Set("Access-Control-Request-Headers", "x-custom")
+
+	w := performRequestWithHeaders(newTestRouter(config), "OPTIONS", "/", "http://google.com", h.Clone())
+	full := w.Header()
+	assert.Equal(t, []string{"Access-Control-Request-Method", "Access-Control-Request-Headers"}, full.Values("Vary"))
+
+	config.MinimalHeaders = true
+	w = performRequestWithHeaders(newTestRouter(config), "OPTIONS", "/", "http://google.com", h.Clone())
+	minimal := w.Header()
+	assert.Empty(t, minimal.Values("Vary"))
+	full.Del("Vary")
+	assert.Equal(t, full, minimal)
+
+	config.StrictMode = true
+	assert.Error(t, config.Validate())
+}
+
+func TestValidateHeaderTokens(t *testing.T) {
+	config := Config{
+		AllowAllOrigins: true,
+		AllowHeaders:    []string{"Content-Type", " x-custom ", "*"},
+		ExposeHeaders:   []string{"X-Request-Id", "x_legacy"},
+	}
+	assert.NoError(t, config.Validate())
+
+	for _, header := range []string{"X Custom", "X-Custom\r\nSet-Cookie: a", "X:Custom", "X-Cüstom", ""} {
+		config.AllowHeaders = []string{"Content-Type", header}
+		assert.Error(t, config.Validate(), header)
+		config.AllowHeaders = nil
+		config.ExposeHeaders = []string{header}
+		assert.Error(t, config.Validate(), header)
+		config.ExposeHeaders = nil
+	}
+}
+
+func TestSilentPreflightDeny(t *testing.T) {
+	config := Config{
+		AllowOrigins:        []string{"http://google.com"},
+		SilentPreflightDeny: true,
+	}
+	router := newTestRouter(config)
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "GET")
+
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://example.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Values("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Values("Access-Control-Allow-Methods"))
+
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// actual requests are still denied
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	config.OptionsResponseStatusCode = http.StatusOK
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://example.com", h.Clone())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Values("Access-Control-Allow-Origin"))
+}
+
+func TestStrictMethodCase(t *testing.T) {
+	config := Config{
+		AllowOrigins:                 []string{"http://google.com"},
+		AllowMethods:                 []string{"GET", "patch"},
+		AllowCredentials:             true,
+		EnforceMethodOnActualRequest: true,
+		EchoMethodIntersection:       true,
+	}
+	preflight := func(router *gin.Engine, method string) string {
+		h := http.Header{}
+		h.Set("Access-Control-Request-Method", method)
+		w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h)
+		return w.Header().Get("Access-Control-Allow-Methods")
+	}
+
+	router := newTestRouter(config)
+	assert.Equal(t, "get", preflight(router, "get"))
+	assert.Equal(t, "GET", preflight(router, "GET"))
+	assert.Equal(t, "patch", preflight(router, "patch"))
+	// the request passes the middleware, the router has no "get" route
+	w := performRequest(router, "get", "http://google.com")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	config.StrictMethodCase = true
+	router = newTestRouter(config)
+	assert.Equal(t, "GET,PATCH", preflight(router, "get"))
+	assert.Equal(t, "GET", preflight(router, "GET"))
+	assert.Equal(t, "PATCH", preflight(router, "PATCH"))
+	assert.Equal(t, http.StatusForbidden, performRequest(router, "get", "http://google.com").Code)
+	assert.Equal(t, http.StatusOK, performRequest(router, "GET", "http://google.com").Code)
+}
+
+func TestConfigMarshalJSON(t *testing.T) {
+	config := Config{
+		AllowOrigins:    []string{"https://example.com"},
+		AllowOriginURLs: []*url.URL{{Scheme: "https", Host: "app.example.com"}},
+		AllowMethods:    []string{"GET"},
+		AllowOriginFunc: func(origin string) bool { return true },
+		MaxAge:          12 * time.Hour,
+		MaxAgeByOrigin:  map[string]time.Duration{"https://example.com": time.Minute},
+	}
+	b, err := json.Marshal(config)
+	assert.NoError(t, err)
+
+	var decoded map[string]any
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, true, decoded["allowOriginFunc"])
+	assert.Equal(t, false, decoded["allowOriginWithContextFunc"])
+	assert.Equal(t, false, decoded["originStore"])
+	assert.Equal(t, float64(43200), decoded["maxAge"])
+	assert.Equal(t, map[string]any{"https://example.com": float64(60)}, decoded["maxAgeByOrigin"])
+	assert.Equal(t, []any{"https://app.example.com"}, decoded["allowOriginURLs"])
+	assert.Equal(t, false, decoded["allowAllOrigins"])
+
+	// plain fields round-trip
+	var roundTrip struct {
+		AllowOrigins []string
+		AllowMethods []string
+		MaxAge       int64
+	}
+	assert.NoError(t, json.Unmarshal(b, &roundTrip))
+	assert.Equal(t, config.AllowOrigins, roundTrip.AllowOrigins)
+	assert.Equal(t, config.AllowMethods, roundTrip.AllowMethods)
+	assert.Equal(t, config.MaxAge, time.Duration(roundTrip.MaxAge)*time.Second)
+
+	// nil URLs are displayed, not dereferenced
+	config.AllowOriginURLs = []*url.URL{nil, {Scheme: "https", Host: "app.example.com"}}
+	b, err = json.Marshal(config)
+	assert.NoError(t, err)
+	decoded = nil
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, []any{nil, "https://app.example.com"}, decoded["allowOriginURLs"])
+}
+
+func TestAllowHeadersFunc(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowHeaders: []string{"Content-Type"},
+		AllowHeadersFunc: func(c *gin.Context, origin string) []string {
+			switch c.GetString("scope") {
+			case "admin":
+				return []string{"x-admin-token", "X-Audit"}
+			case "any":
+				return []string{"*"}
+			}
+			return nil
+		},
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("scope", c.GetHeader("X-Test-Scope"))
+	})
+	router.Use(New(config))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "get")
+	})
+
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "GET")
+	h.Set("X-Test-Scope", "admin")
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type,X-Admin-Token,X-Audit", w.Header().Get("Access-Control-Allow-Headers"))
+
+	h.Set("X-Test-Scope", "user")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+
+	// the "*" header name is only allowed to the scope allowing it
+	h.Set("Access-Control-Request-Headers", "*")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	h.Set("X-Test-Scope", "any")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type,*", w.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestAllowOriginsCSV(t *testing.T) {
+	config := Config{
+		AllowOriginsCSV: " http://google.com ,http://github.com,, https://*.example.com ",
+		AllowMethods:    []string{"GET"},
+		AllowWildcard:   true,
+	}
+	assert.NoError(t, config.Validate())
+	router := newTestRouter(config)
+	for _, origin := range []string{"http://google.com", "http://github.com", "https://api.example.com"} {
+		w := performRequest(router, "GET", origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+	w := performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// AllowOrigins takes precedence
+	config.AllowOrigins = []string{"http://example.com"}
+	router = newTestRouter(config)
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, "http://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	config = Config{AllowOriginsCSV: "http://google.com,google.com"}
+	assert.Error(t, config.Validate())
+	config = Config{AllowOriginsCSV: "http://google.com", AllowAllOrigins: true}
+	assert.Error(t, config.Validate())
+}
+
+func TestRequireSchemeMatch(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins:       []string{"http://google.com", "https://google.com"},
+		RequireSchemeMatch: true,
+	})
+	w := performRequestWithTLS(router, "https://google.com", &tls.ConnectionState{})
+	assert.Equal(t, "https://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequestWithTLS(router, "http://google.com", nil)
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// downgraded origin over TLS
+	w = performRequestWithTLS(router, "http://google.com", &tls.ConnectionState{})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestWithTLS(router, "https://google.com", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestNoPreflightMethods(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins:       []string{"http://google.com"},
+		AllowMethods:       []string{"GET", "PUT"},
+		ExposeHeaders:      []string{"X-Total"},
+		NoPreflightMethods: []string{"get"},
+	})
+	router.OPTIONS("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "options")
+	})
+
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "GET")
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "options", w.Body.String())
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Total", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+
+	h.Set("Access-Control-Request-Method", "PUT")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET,PUT", w.Header().Get("Access-Control-Allow-Methods"))
+
+	assert.Error(t, Config{AllowAllOrigins: true, NoPreflightMethods: []string{"G ET"}}.Validate())
+}
+
+type fakeResolver struct {
+	hosts   map[string][]string
+	lookups int
+}
+
+func (r *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups++
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	ips := make([]net.IPAddr, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = net.IPAddr{IP: net.ParseIP(addr)}
+	}
+	return ips, nil
+}
+
+func TestAllowOriginByResolvedIP(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	resolver := &fakeResolver{hosts: map[string][]string{
+		"app.internal":   {"192.168.1.1", "10.1.2.3"},
+		"evil.example":   {"203.0.113.7"},
+		"legacy.example": {"10.9.9.9"},
+	}}
+	router := newTestRouter(Config{
+		AllowOrigins:            []string{"http://google.com"},
+		AllowOriginByResolvedIP: trusted.Contains,
+		OriginResolver:          resolver,
+	})
+
+	w := performRequest(router, "GET", "https://app.internal")
+	assert.Equal(t, "https://app.internal", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "http://LEGACY.example:8080")
+	assert.Equal(t, "http://LEGACY.example:8080", w.Header().Get("Access-Control-Allow-Origin"))
+	w = performRequest(router, "GET", "https://evil.example")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, "GET", "https://unknown.example")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, "GET", "http://10.0.0.1")
+	assert.Equal(t, "http://10.0.0.1", w.Header().Get("Access-Control-Allow-Origin"))
+	// listed origins are not resolved
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, 4, resolver.lookups)
+
+	// successful lookups are cached, failed ones retried
+	performRequest(router, "GET", "https://app.internal")
+	performRequest(router, "GET", "https://unknown.example")
+	assert.Equal(t, 5, resolver.lookups)
+
+	assert.Error(t, Config{AllowAllOrigins: true, AllowOriginByResolvedIP: trusted.Contains}.Validate())
+}
+
+func TestRouteAllowHeaders(t *testing.T) {
+	routeHeaders := map[string][]string{
+		"/upload": {"content-range", "X-Upload-Id"},
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if headers, ok := routeHeaders[c.Request.URL.Path]; ok {
+			c.Set(RouteAllowHeaders, headers)
+		}
+	})
+	router.Use(New(Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowHeaders: []string{"Content-Type"},
+	}))
+	router.POST("/upload", func(c *gin.Context) {
+		c.String(http.StatusOK, "upload")
+	})
+	router.POST("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "post")
+	})
+
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "POST")
+	w := performRequestWithHeaders(router, "OPTIONS", "/upload", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type,Content-Range,X-Upload-Id", w.Header().Get("Access-Control-Allow-Headers"))
+
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestMaxAllowHeadersBytes(t *testing.T) {
+	config := Config{
+		AllowOrigins:               []string{"http://google.com"},
+		AllowHeaders:               []string{"Content-Type"},
+		AllowExtraRequestedHeaders: true,
+		MaxAllowHeadersBytes:       len("Content-Type,X-A"),
+	}
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "GET")
+	h.Set("Access-Control-Request-Headers", "x-a")
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "Content-Type,X-A", w.Header().Get("Access-Control-Allow-Headers"))
+
+	// one byte over the limit: only the configured headers are sent
+	config.MaxAllowHeadersBytes--
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+
+	config.AllowExtraRequestedHeaders = false
+	config.SkipRequestHeaderValidation = true
+	config.AllowHeaders = nil
+	config.MaxAllowHeadersBytes = 3
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "X-A", w.Header().Get("Access-Control-Allow-Headers"))
+	h.Set("Access-Control-Request-Headers", "x-a,x-b")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Empty(t, w.Header().Values("Access-Control-Allow-Headers"))
+
+	config = Config{AllowAllOrigins: true, AllowHeaders: []string{"Content-Type"}, MaxAllowHeadersBytes: 11}
+	assert.Error(t, config.Validate())
+	config.MaxAllowHeadersBytes = 12
+	assert.NoError(t, config.Validate())
+}
+
+func TestTreatEmptyOriginAsCORS(t *testing.T) {
+	config := Config{AllowAllOrigins: true}
+	h := http.Header{"Origin": {""}}
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "GET", "/", "", h.Clone())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	config.TreatEmptyOriginAsCORS = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "GET", "/", "", h.Clone())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	// requests without an Origin header are still not CORS requests
+	w = performRequest(router, "GET", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAllowOriginFuncs(t *testing.T) {
+	var calls []string
+	router := newTestRouter(Config{
+		AllowOriginFuncs: []func(string) bool{
+			func(origin string) bool {
+				calls = append(calls, "first")
+				return origin == "http://google.com"
+			},
+			func(origin string) bool {
+				calls = append(calls, "second")
+				return origin == "http://github.com"
+			},
+		},
+	})
+
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "http://google.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"first"}, calls)
+
+	calls = nil
+	w = performRequest(router, "GET", "http://github.com")
+	assert.Equal(t, "http://github.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"first", "second"}, calls)
+
+	w = performRequest(router, "GET", "http://example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Error(t, Config{AllowOriginFuncs: []func(string) bool{nil}}.Validate())
+	assert.Error(t, Config{
+		AllowAllOrigins:  true,
+		AllowOriginFuncs: []func(string) bool{func(string) bool { return true }},
+	}.Validate())
+}
+
+func TestWildcardAnyPort(t *testing.T) {
+	config := Config{
+		AllowOrigins:  []string{"https://*.example.com", "http://*.internal:*"},
+		AllowWildcard: true,
+	}
+	assert.NoError(t, config.Validate())
+	router := newTestRouter(config)
+
+	// the port breaks the suffix match of rules without a port wildcard
+	w := performRequest(router, "GET", "https://api.example.com:8443")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, "GET", "https://api.example.com")
+	assert.Equal(t, "https://api.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	for _, origin := range []string{"http://app.internal:8443", "http://app.internal:80", "http://app.internal"} {
+		w = performRequest(router, "GET", origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+	denied := []string{"https://app.internal:8443", "http://app.internal:x", "http://app.internal.evil.com:80"}
+	for _, origin := range denied {
+		w = performRequest(router, "GET", origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+
+	config.AllowOrigins = []string{"https://*.*.example.com:*"}
+	assert.Error(t, config.Validate())
+}
+
+func TestFallbackToHostOrigin(t *testing.T) {
+	config := Config{
+		AllowOrigins:  []string{"http://internal.example.com"},
+		ExposeHeaders: []string{"X-Total"},
+	}
+	h := http.Header{}
+	h.Set("Host", "internal.example.com")
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "GET", "/", "", h.Clone())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	config.FallbackToHostOrigin = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "GET", "/", "", h.Clone())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "http://internal.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Total", w.Header().Get("Access-Control-Expose-Headers"))
+
+	// a host origin that is not allowed gets no header but is not denied
+	h.Set("Host", "other.example.com")
+	w = performRequestWithHeaders(router, "GET", "/", "", h.Clone())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestWarningsAllowHeaders(t *testing.T) {
+	config := Config{
+		AllowOrigins: []string{"http://google.com"},
+		AllowMethods: []string{"GET", "post"},
+		AllowHeaders: []string{"X-Request-Id"},
+	}
+	warnings := config.Warnings()
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "AllowHeaders omits Content-Type while AllowMethods includes POST")
+
+	config.AllowCredentials = true
+	warnings = config.Warnings()
+	assert.Len(t, warnings, 2)
+	assert.Contains(t, warnings[1], "AllowHeaders omits Authorization")
+
+	config.AllowHeaders = []string{"content-type", "Authorization"}
+	assert.Empty(t, config.Warnings())
+
+	config.AllowHeaders = []string{"Authorization"}
+	config.IncludeSimpleHeaders = true
+	assert.Empty(t, config.Warnings())
+
+	config.AllowHeaders = []string{"*"}
+	config.IncludeSimpleHeaders = false
+	assert.Empty(t, config.Warnings())
+
+	// Content-Type is only needed by methods sending a body
+	config.AllowHeaders = []string{"Authorization"}
+	config.AllowMethods = []string{"GET", "DELETE"}
+	assert.Empty(t, config.Warnings())
+
+	// an empty AllowHeaders is deliberate, unless credentials are allowed
+	config = Config{AllowOrigins: []string{"http://google.com"}, AllowMethods: []string{"POST"}}
+	assert.Empty(t, config.Warnings())
+	config.AllowCredentials = true
+	assert.Len(t, config.Warnings(), 1)
+}
+
+func TestWarningsCredentialedAllOrigins(t *testing.T) {
+	config := Config{AllowAllOrigins: true, AllowCredentials: true, AllowHeaders: []string{"Authorization"}}
+	warnings := config.Warnings()
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "every origin is reflected")
+
+	config.AllowCredentialsFunc = func(origin string) bool { return origin == "https://app.example.com" }
+	assert.Empty(t, config.Warnings())
+}
+
+func TestNewDynamicCache(t *testing.T) {
+	now := time.Now()
+	compiled := map[string]int{}
+	router := gin.New()
+	router.Use(newDynamic(DynamicConfig{
+		Provider: func(c *gin.Context) Config {
+			compiled[c.Request.Host]++
+			return Config{AllowOrigins: []string{"http://" + c.Request.Host + ".client"}}
+		},
+		CacheKey:  func(c *gin.Context) string { return c.Request.Host },
+		CacheTTL:  time.Minute,
+		CacheSize: 2,
+	}, func() time.Time { return now }))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "get")
+	})
+	send := func(host string) *httptest.ResponseRecorder {
+		h := http.Header{}
+		h.Set("Host", host)
+		return performRequestWithHeaders(router, "GET", "/", "http://"+host+".client", h)
+	}
+
+	for i := 0; i < 3; i++ {
+		w := send("a")
+		assert.Equal(t, "http://a.client", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	assert.Equal(t, 1, compiled["a"])
+
+	now = now.Add(30 * time.Second)
+	send("b")
+	send("b")
+	assert.Equal(t, 1, compiled["b"])
+
+	// a has expired, b is still cached
+	now = now.Add(31 * time.Second)
+	send("a")
+	send("b")
+	assert.Equal(t, 2, compiled["a"])
+	assert.Equal(t, 1, compiled["b"])
+
+	// the cache is full: c evicts b, which expires first
+	send("c")
+	send("a")
+	send("b")
+	assert.Equal(t, map[string]int{"a": 2, "b": 2, "c": 1}, compiled)
+}
+
+func TestLegacyHeaderFormat(t *testing.T) {
+	config := Config{
+		AllowOrigins:  []string{"http://google.com"},
+		AllowMethods:  []string{"GET", "POST"},
+		AllowHeaders:  []string{"Content-Type", "Authorization"},
+		ExposeHeaders: []string{"X-Total", "X-Page"},
+	}
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "POST")
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "GET,POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type,Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "X-Total,X-Page", w.Header().Get("Access-Control-Expose-Headers"))
+
+	config.LegacyHeaderFormat = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		w.Header().Values("Vary"))
+	w = performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "X-Total, X-Page", w.Header().Get("Access-Control-Expose-Headers"))
+
+	// headers added per request use the same separator
+	config.AllowExtraRequestedHeaders = true
+	router = newTestRouter(config)
+	h.Set("Access-Control-Request-Headers", "x-custom")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "Content-Type, Authorization, X-Custom", w.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestDenyServerHostnameOrigins(t *testing.T) {
+	config := Config{
+		AllowOrigins:    []string{"https://*.example.com:*"},
+		AllowWildcard:   true,
+		ServerHostnames: []string{"API.example.com"},
+	}
+	h := http.Header{}
+	h.Set("Host", "api.example.com")
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "GET", "/", "https://api.example.com:8443", h.Clone())
+	assert.Equal(t, "https://api.example.com:8443", w.Header().Get("Access-Control-Allow-Origin"))
+
+	config.DenyServerHostnameOrigins = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "GET", "/", "https://api.example.com:8443", h.Clone())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestWithHeaders(router, "GET", "/", "https://app.example.com", h.Clone())
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// same-origin requests pass through
+	w = performRequestWithHeaders(router, "GET", "/", "https://api.example.com", h.Clone())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	config.AllowSameOrigin = true
+	router = newTestRouter(config)
+	w = performRequestWithHeaders(router, "GET", "/", "https://api.example.com:443", h.Clone())
+	assert.Equal(t, "https://api.example.com:443", w.Header().Get("Access-Control-Allow-Origin"))
+
+	assert.Error(t, Config{AllowAllOrigins: true, DenyServerHostnameOrigins: true}.Validate())
+	assert.Error(t, Config{AllowAllOrigins: true, ServerHostnames: []string{"https://api.example.com"}}.Validate())
+}
+
+func TestAllowOriginsStarAlone(t *testing.T) {
+	router := newTestRouter(Config{AllowOrigins: []string{"*"}})
+	w := performRequest(router, "GET", "http://google.com")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+
+	for _, origins := range [][]string{
+		{"*", "https://specific.com"},
+		{"https://specific.com", " * "},
+		{"*", "*"},
+	} {
+		config := Config{AllowOrigins: origins}
+		err := config.Validate()
+		if assert.Error(t, err, origins) {
+			assert.Contains(t, err.Error(), `mixes "*" with other origins`)
+		}
+		assert.Panics(t, func() { New(config) })
+	}
+
+	// wildcard rules containing '*' are not affected
+	assert.NoError(t, Config{
+		AllowOrigins:  []string{"https://*.example.com", "https://specific.com"},
+		AllowWildcard: true,
+	}.Validate())
+}
+
+func TestEchoHeaderIntersection(t *testing.T) {
+	config := Config{
+		AllowOrigins:           []string{"http://google.com"},
+		AllowHeaders:           []string{"Content-Type", "Authorization", "X-Request-Id"},
+		EchoHeaderIntersection: true,
+	}
+	h := http.Header{}
+	h.Set("Access-Control-Request-Method", "POST")
+	h.Set("Access-Control-Request-Headers", "authorization,x-forbidden,content-type")
+
+	router := newTestRouter(config)
+	w := performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Authorization,Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+
+	h.Set("Access-Control-Request-Headers", "x-forbidden,*")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Values("Access-Control-Allow-Headers"))
+
+	h.Del("Access-Control-Request-Headers")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Values("Access-Control-Allow-Headers"))
+
+	// without the option the configured list is sent and "*" is denied
+	config.EchoHeaderIntersection = false
+	router = newTestRouter(config)
+	h.Set("Access-Control-Request-Headers", "authorization,x-forbidden")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, "Content-Type,Authorization,X-Request-Id", w.Header().Get("Access-Control-Allow-Headers"))
+	h.Set("Access-Control-Request-Headers", "x-forbidden,*")
+	w = performRequestWithHeaders(router, "OPTIONS", "/", "http://google.com", h.Clone())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestIdempotentMaxAge(t *testing.T) {
+	router := newTestRouter(Config{
+		AllowOrigins:     []string{"http://google.com", "http://github.com"},
+		AllowMethods:     []string{"GET", "HEAD", "POST"},
+		MaxAge:           10 * time.Minute,
+		IdempotentMaxAge: 2 * time.Hour,
+		MaxAgeByOrigin:   map[string]time.Duration{"http://github.com": time.Minute},
+	})
+	preflight := func(method, origin string) *httptest.ResponseRecorder {
+		h := http.Header{}
+		h.Set("Access-Control-Request-Method", method)
+		return performRequestWithHeaders(router, "OPTIONS", "/", origin, h)
+	}
+
+	for _, method := range []string{"GET", "HEAD", "get"} {
+		w := preflight(method, "http://google.com")
+		assert.Equal(t, "7200", w.Header().Get("Access-Control-Max-Age"), method)
+	}
+	w := preflight("POST", "http://google.com")
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	// MaxAgeByOrigin takes precedence
+	w = preflight("GET", "http://github.com")
+	assert.Equal(t, "60", w.Header().Get("Access-Control-Max-Age"))
+
+	// with "*" the preflight response still varies on the requested method
+	router = newTestRouter(Config{
+		AllowAllOrigins:  true,
+		AllowMethods:     []string{"GET", "POST"},
+		MaxAge:           10 * time.Minute,
+		IdempotentMaxAge: 2 * time.Hour,
+	})
+	for _, method := range []string{"GET", "POST"} {
+		w = preflight(method, "http://google.com")
+		assert.Contains(t, w.Header().Values("Vary"), "Access-Control-Request-Method", method)
+	}
+
+	assert.Error(t, Config{AllowAllOrigins: true, IdempotentMaxAge: -time.Second}.Validate())
+	assert.Len(t, Config{AllowAllOrigins: true, IdempotentMaxAge: 24 * time.Hour}.Warnings(), 1)
+}
@@ -0,0 +1,34 @@
+package cors
+
+import "github.com/gin-gonic/gin"
+
+// Reasons reported by DenialFromContext.
+const (
+	DenialOriginNotAllowed = "origin not allowed"
+	DenialMethodNotAllowed = "method not allowed"
+	DenialSecFetchSite     = "sec-fetch-site not allowed"
+	DenialOriginFuncPanic  = "origin func panicked"
+	DenialHeaderNotAllowed = "header not allowed"
+	DenialTooManyHeaders   = "too many headers requested"
+)
+
+const denialContextKey = "github.com/gin-contrib/cors/denial"
+
+type denial struct {
+	origin string
+	reason string
+}
+
+// DenialFromContext returns the origin and reason of the CORS denial of the
+// request, if the middleware denied it.
+func DenialFromContext(c *gin.Context) (origin, reason string, ok bool) {
+	v, exists := c.Get(denialContextKey)
+	if !exists {
+		return "", "", false
+	}
+	d, ok := v.(denial)
+	if !ok {
+		return "", "", false
+	}
+	return d.origin, d.reason, true
+}
@@ -0,0 +1,106 @@
+package cors
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Kinds of ConfigChange.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// ConfigChange is a difference between two configs reported by Diff.
+// Value is the list item added or removed; Old and New are the formatted
+// values of a changed field.
+type ConfigChange struct {
+	Field string
+	Kind  string
+	Value string
+	Old   string
+	New   string
+}
+
+// Diff returns the changes from a to b, field by field in declaration order.
+// Items of string lists, such as AllowOrigins, are reported as added or
+// removed; other fields as changed. Funcs and interfaces can not be compared
+// and are only reported as changed when one of them is nil.
+func Diff(a, b Config) []ConfigChange {
+	var changes []ConfigChange
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i).Name
+		fa, fb := va.Field(i), vb.Field(i)
+		switch {
+		case fa.Kind() == reflect.Func || fa.Kind() == reflect.Interface && fa.Type().NumMethod() > 0:
+			if fa.IsNil() != fb.IsNil() {
+				changes = append(changes, ConfigChange{
+					Field: field,
+					Kind:  ChangeChanged,
+					Old:   formatNil(fa.IsNil()),
+					New:   formatNil(fb.IsNil()),
+				})
+			}
+		case fa.Kind() == reflect.Slice && fa.Type().Elem().Kind() == reflect.String:
+			changes = append(changes, diffStrings(field, toStrings(fa), toStrings(fb))...)
+		case !reflect.DeepEqual(fa.Interface(), fb.Interface()):
+			changes = append(changes, ConfigChange{
+				Field: field,
+				Kind:  ChangeChanged,
+				Old:   fmt.Sprint(fa.Interface()),
+				New:   fmt.Sprint(fb.Interface()),
+			})
+		}
+	}
+	return changes
+}
+
+func diffStrings(field string, a, b []string) []ConfigChange {
+	var changes []ConfigChange
+	before := stringSet(a)
+	after := stringSet(b)
+	for _, value := range a {
+		value = strings.TrimSpace(value)
+		if after[value] {
+			continue
+		}
+		after[value] = true
+		changes = append(changes, ConfigChange{Field: field, Kind: ChangeRemoved, Value: value})
+	}
+	for _, value := range b {
+		value = strings.TrimSpace(value)
+		if before[value] {
+			continue
+		}
+		before[value] = true
+		changes = append(changes, ConfigChange{Field: field, Kind: ChangeAdded, Value: value})
+	}
+	return changes
+}
+
+func toStrings(v reflect.Value) []string {
+	values := make([]string, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).String()
+	}
+	return values
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[strings.TrimSpace(value)] = true
+	}
+	return set
+}
+
+func formatNil(isNil bool) string {
+	if isNil {
+		return "nil"
+	}
+	return "set"
+}
@@ -0,0 +1,168 @@
+package cors
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDynamicCacheTTL  = time.Minute
+	defaultDynamicCacheSize = 1024
+)
+
+// DynamicConfig represents the options for a middleware whose CORS
+// configuration is provided per request.
+type DynamicConfig struct {
+	// Provider returns the configuration to apply to the request.
+	Provider func(c *gin.Context) Config
+
+	// ErrorStatusCode is the status used to deny a request when Provider
+	// returns an invalid configuration. Default value is 403
+	ErrorStatusCode int
+
+	// OnError is called with the validation error when Provider returns an
+	// invalid configuration.
+	OnError func(c *gin.Context, err error)
+
+	// CacheKey returns the key under which the configuration compiled for the
+	// request is cached, e.g. the request host, when Provider returns the same
+	// configuration for every request with that key. Provider is not called
+	// for requests whose key is cached. An empty key disables the cache for
+	// the request. Default value is nil (no cache)
+	//
+	// Options keeping state in the compiled configuration only keep it while
+	// it is cached: LogNewOrigins, the addresses cached for
+	// AllowOriginByResolvedIP and OnCompile start over with every compilation,
+	// so without CacheKey they behave as if each request were the first one.
+	CacheKey func(c *gin.Context) string
+
+	// CacheTTL is how long a compiled configuration is cached.
+	// Default value is one minute
+	CacheTTL time.Duration
+
+	// CacheSize is the maximum number of cached configurations. When full,
+	// expired entries are evicted, then the one expiring first.
+	// Default value is 1024
+	CacheSize int
+}
+
+// NewDynamic returns the location middleware with a configuration built per
+// request. Requests whose configuration is invalid fail closed: they are
+// aborted with ErrorStatusCode instead of panicking. The configuration is
+// compiled for every request unless CacheKey is set, which stateful options
+// such as LogNewOrigins and AllowOriginByResolvedIP need.
+func NewDynamic(config DynamicConfig) gin.HandlerFunc {
+	return newDynamic(config, time.Now)
+}
+
+func newDynamic(config DynamicConfig, now func() time.Time) gin.HandlerFunc {
+	if config.Provider == nil {
+		panic("cors: DynamicConfig.Provider is required")
+	}
+	if config.ErrorStatusCode == 0 {
+		config.ErrorStatusCode = http.StatusForbidden
+	}
+	var cache *dynamicCache
+	if config.CacheKey != nil {
+		cache = newDynamicCache(config.CacheTTL, config.CacheSize, now)
+	}
+	return func(c *gin.Context) {
+		var key string
+		if cache != nil {
+			if key = config.CacheKey(c); key != "" {
+				if cors, ok := cache.get(key); ok {
+					cors.applyCors(c)
+					return
+				}
+			}
+		}
+		cors, err := compileCors(config.Provider(c))
+		if err != nil {
+			if config.OnError != nil {
+				config.OnError(c, err)
+			}
+			c.AbortWithStatus(config.ErrorStatusCode)
+			return
+		}
+		if key != "" {
+			cache.set(key, cors)
+		}
+		cors.applyCors(c)
+	}
+}
+
+type dynamicEntry struct {
+	cors    *cors
+	expires time.Time
+}
+
+// dynamicCache holds the configurations compiled by NewDynamic per key.
+type dynamicCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]dynamicEntry
+}
+
+func newDynamicCache(ttl time.Duration, size int, now func() time.Time) *dynamicCache {
+	if ttl <= 0 {
+		ttl = defaultDynamicCacheTTL
+	}
+	if size <= 0 {
+		size = defaultDynamicCacheSize
+	}
+	return &dynamicCache{
+		ttl:     ttl,
+		size:    size,
+		now:     now,
+		entries: make(map[string]dynamicEntry),
+	}
+}
+
+func (d *dynamicCache) get(key string) (*cors, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !d.now().Before(entry.expires) {
+		delete(d.entries, key)
+		return nil, false
+	}
+	return entry.cors, true
+}
+
+func (d *dynamicCache) set(key string, cors *cors) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	if _, ok := d.entries[key]; !ok && len(d.entries) >= d.size {
+		d.evict(now)
+	}
+	d.entries[key] = dynamicEntry{cors: cors, expires: now.Add(d.ttl)}
+}
+
+// evict removes the expired entries, or the entry expiring first when none
+// has expired.
+func (d *dynamicCache) evict(now time.Time) {
+	var first string
+	var firstExpires time.Time
+	for key, entry := range d.entries {
+		if !now.Before(entry.expires) {
+			delete(d.entries, key)
+			continue
+		}
+		if first == "" || entry.expires.Before(firstExpires) {
+			first, firstExpires = key, entry.expires
+		}
+	}
+	if len(d.entries) >= d.size {
+		delete(d.entries, first)
+	}
+}
@@ -0,0 +1,109 @@
+package cors
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Resolver looks up the IP addresses of a host. It is implemented by
+// *net.Resolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+const (
+	defaultResolvedIPCacheTTL = time.Minute
+	maxResolvedIPCacheEntries = 1024
+)
+
+type resolvedHost struct {
+	ips     []net.IPAddr
+	expires time.Time
+}
+
+// ipResolver checks origin hosts against AllowOriginByResolvedIP, caching
+// the addresses of successful lookups for ttl.
+type ipResolver struct {
+	resolver Resolver
+	allow    func(ip net.IP) bool
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]resolvedHost
+}
+
+// newIPResolver returns nil when allow is nil.
+func newIPResolver(resolver Resolver, allow func(ip net.IP) bool, ttl time.Duration) *ipResolver {
+	if allow == nil {
+		return nil
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if ttl <= 0 {
+		ttl = defaultResolvedIPCacheTTL
+	}
+	return &ipResolver{
+		resolver: resolver,
+		allow:    allow,
+		ttl:      ttl,
+		now:      time.Now,
+		hosts:    make(map[string]resolvedHost),
+	}
+}
+
+// allowed reports whether one of the addresses of the origin host is allowed.
+// Hosts that are IP literals are checked without a lookup.
+func (r *ipResolver) allowed(ctx context.Context, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := asciiLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil {
+		return r.allow(ip)
+	}
+	ips, ok := r.lookup(ctx, host)
+	if !ok {
+		return false
+	}
+	for _, ip := range ips {
+		if r.allow(ip.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ipResolver) lookup(ctx context.Context, host string) ([]net.IPAddr, bool) {
+	now := r.now()
+	r.mu.Lock()
+	cached, ok := r.hosts[host]
+	r.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.ips, true
+	}
+
+	ips, err := r.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, false
+	}
+	r.mu.Lock()
+	if len(r.hosts) >= maxResolvedIPCacheEntries {
+		for h, entry := range r.hosts {
+			if !now.Before(entry.expires) {
+				delete(r.hosts, h)
+			}
+		}
+		if len(r.hosts) >= maxResolvedIPCacheEntries {
+			r.hosts = make(map[string]resolvedHost)
+		}
+	}
+	r.hosts[host] = resolvedHost{ips: ips, expires: now.Add(r.ttl)}
+	r.mu.Unlock()
+	return ips, true
+}
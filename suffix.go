@@ -0,0 +1,88 @@
+package cors
+
+import "strings"
+
+// suffixTrie matches origins against wildcard rules of the form
+// scheme://*.example.com or *.example.com in time proportional to the number
+// of labels of the origin host rather than the number of rules.
+type suffixTrie struct {
+	root  suffixNode
+	rules int
+}
+
+type suffixNode struct {
+	children map[string]*suffixNode
+	// prefixes are the rule prefixes, "scheme://" or "*", ending at this node.
+	prefixes []string
+}
+
+// isSuffixRule reports whether the wildcard rule w can be stored in a
+// suffixTrie: its prefix is a bare scheme or "*" and its suffix a domain
+// starting with a dot.
+func isSuffixRule(w []string) bool {
+	prefix, suffix := w[0], w[1]
+	if prefix != "*" {
+		i := strings.Index(prefix, "://")
+		if i <= 0 || i+len("://") != len(prefix) {
+			return false
+		}
+	}
+	return len(suffix) > 1 && suffix[0] == '.' && !strings.ContainsAny(suffix, "*:/?#@[]")
+}
+
+func (t *suffixTrie) insert(w []string) {
+	node := &t.root
+	labels := strings.Split(w[1][1:], ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, ok := node.children[labels[i]]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*suffixNode)
+			}
+			child = &suffixNode{}
+			node.children[labels[i]] = child
+		}
+		node = child
+	}
+	node.prefixes = append(node.prefixes, w[0])
+	t.rules++
+}
+
+// match reports whether origin matches one of the rules, or is the apex of
+// one of them when matchApex is set.
+func (t *suffixTrie) match(origin string, matchApex bool) bool {
+	i := strings.Index(origin, "://")
+	if i < 0 {
+		return false
+	}
+	scheme, host := origin[:i+len("://")], origin[i+len("://"):]
+	if strings.ContainsAny(host, ":/?#@[]") {
+		return false
+	}
+	node := &t.root
+	for {
+		j := strings.LastIndexByte(host, '.')
+		next, ok := node.children[host[j+1:]]
+		if !ok {
+			return false
+		}
+		node = next
+		if j < 0 {
+			// the whole host is the suffix of the rules of node
+			return matchApex && node.hasPrefix(scheme)
+		}
+		host = host[:j]
+		if node.hasPrefix(scheme) {
+			return true
+		}
+	}
+}
+
+func (n *suffixNode) hasPrefix(scheme string) bool {
+	for _, prefix := range n.prefixes {
+		if prefix == "*" || prefix == scheme {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,12 @@
 package cors
 
 import (
+	"errors"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -9,16 +14,22 @@ import (
 
 type converter func(string) string
 
+// headerEntry is a precomputed header written to the response as is.
+type headerEntry struct {
+	key    string
+	values []string
+}
+
 func generateNormalHeaders(c Config) http.Header {
 	headers := make(http.Header)
-	if c.AllowCredentials {
+	if c.AllowCredentials && c.AllowCredentialsFunc == nil {
 		headers.Set("Access-Control-Allow-Credentials", "true")
 	}
 	if len(c.ExposeHeaders) > 0 {
-		exposeHeaders := convert(normalize(c.ExposeHeaders), http.CanonicalHeaderKey)
-		headers.Set("Access-Control-Expose-Headers", strings.Join(exposeHeaders, ","))
+		exposeHeaders := headerNames(c.ExposeHeaders, c.PreserveHeaderCase)
+		headers.Set("Access-Control-Expose-Headers", strings.Join(exposeHeaders, c.listSeparator()))
 	}
-	if c.AllowAllOrigins {
+	if c.allowOriginStar() {
 		headers.Set("Access-Control-Allow-Origin", "*")
 	} else {
 		headers.Set("Vary", "Origin")
@@ -28,30 +39,39 @@ func generateNormalHeaders(c Config) http.Header {
 
 func generatePreflightHeaders(c Config) http.Header {
 	headers := make(http.Header)
-	if c.AllowCredentials {
+	if c.AllowCredentials && c.AllowCredentialsFunc == nil {
 		headers.Set("Access-Control-Allow-Credentials", "true")
 	}
-	if len(c.AllowMethods) > 0 {
-		allowMethods := convert(normalize(c.AllowMethods), strings.ToUpper)
-		value := strings.Join(allowMethods, ",")
+	methods := c.AllowMethods
+	if len(c.AdvertisedMethods) > 0 {
+		methods = c.AdvertisedMethods
+	}
+	if len(methods) > 0 {
+		allowMethods := convert(normalize(methods), strings.ToUpper)
+		value := strings.Join(allowMethods, c.listSeparator())
 		headers.Set("Access-Control-Allow-Methods", value)
 	}
-	if len(c.AllowHeaders) > 0 {
-		allowHeaders := convert(normalize(c.AllowHeaders), http.CanonicalHeaderKey)
-		value := strings.Join(allowHeaders, ",")
+	allowHeaders := c.allowHeaderList()
+	if len(allowHeaders) > 0 {
+		allowHeaders = headerNames(allowHeaders, c.PreserveHeaderCase)
+		value := strings.Join(allowHeaders, c.listSeparator())
 		headers.Set("Access-Control-Allow-Headers", value)
 	}
-	if c.MaxAge > time.Duration(0) {
-		value := strconv.FormatInt(int64(c.MaxAge/time.Second), 10)
+	if value := formatMaxAge(c.getMaxAge()); value != "" {
 		headers.Set("Access-Control-Max-Age", value)
 	}
 
-	if c.AllowPrivateNetwork {
+	// in strict mode the private network header depends on the request
+	if c.AllowPrivateNetwork && !c.StrictMode {
 		headers.Set("Access-Control-Allow-Private-Network", "true")
 	}
 
-	if c.AllowAllOrigins {
+	if c.allowOriginStar() {
 		headers.Set("Access-Control-Allow-Origin", "*")
+		if c.StrictMode {
+			headers.Add("Vary", "Access-Control-Request-Method")
+			headers.Add("Vary", "Access-Control-Request-Headers")
+		}
 	} else {
 		// Always set Vary headers
 		// see https://github.com/rs/cors/issues/10,
@@ -61,9 +81,414 @@ func generatePreflightHeaders(c Config) http.Header {
 		headers.Add("Vary", "Access-Control-Request-Method")
 		headers.Add("Vary", "Access-Control-Request-Headers")
 	}
+
+	for key, values := range c.PreflightResponseHeaders {
+		for _, value := range values {
+			headers.Add(key, value)
+		}
+	}
 	return headers
 }
 
+func formatMaxAge(maxAge time.Duration) string {
+	if maxAge <= time.Duration(0) {
+		return ""
+	}
+	return strconv.FormatInt(int64(maxAge/time.Second), 10)
+}
+
+// generateMaxAgeByOrigin returns the Access-Control-Max-Age values keyed by
+// normalized origin; an empty value means the header is omitted.
+func generateMaxAgeByOrigin(c Config) map[string]string {
+	if len(c.MaxAgeByOrigin) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(c.MaxAgeByOrigin))
+	for origin, maxAge := range c.MaxAgeByOrigin {
+		values[asciiLower(strings.TrimSpace(origin))] = formatMaxAge(maxAge)
+	}
+	return values
+}
+
+var regexpBasedOrigin = regexp.MustCompile(`^/(.+)/([a-z]*)$`)
+
+func isRegexpOrigin(origin string) bool {
+	return regexpBasedOrigin.MatchString(origin)
+}
+
+// compileRegexpOrigins returns the regular expressions of the /pattern/flags
+// entries of origins.
+func compileRegexpOrigins(origins []string) []*regexp.Regexp {
+	var regexps []*regexp.Regexp
+	for _, origin := range origins {
+		if isRegexpOrigin(origin) {
+			re, _ := compileRegexpOrigin(origin)
+			regexps = append(regexps, re)
+		}
+	}
+	return regexps
+}
+
+// compileRegexpOrigin compiles an origin of the form /pattern/flags,
+// translating the flags into Go inline flags.
+func compileRegexpOrigin(origin string) (*regexp.Regexp, error) {
+	m := regexpBasedOrigin.FindStringSubmatch(origin)
+	if m == nil {
+		return nil, errors.New("bad origin: " + origin + " is not a regular expression")
+	}
+
+	var flags string
+	for _, f := range m[2] {
+		switch f {
+		case 'i', 's', 'm':
+			if !strings.ContainsRune(flags, f) {
+				flags += string(f)
+			}
+		case 'g':
+		default:
+			return nil, errors.New("bad origin: unknown regular expression flag '" + string(f) + "' in " + origin)
+		}
+	}
+
+	pattern := "^(?:" + m[1] + ")$"
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// portRangeOrigin is an origin whose port may be any of min to max.
+type portRangeOrigin struct {
+	origin   string
+	min, max int
+}
+
+// parsePortRangeOrigins returns the port ranges of origins.
+func parsePortRangeOrigins(origins []string) []portRangeOrigin {
+	var ranges []portRangeOrigin
+	for _, origin := range origins {
+		if r, ok, _ := parsePortRangeOrigin(origin); ok {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// parsePortRangeOrigin parses an origin ending with a port range such as
+// http://localhost:3000-3010. It returns false when origin has no port range
+// and an error when the range is malformed.
+func parsePortRangeOrigin(origin string) (portRangeOrigin, bool, error) {
+	origin = strings.TrimSpace(origin)
+	i := strings.Index(origin, "://")
+	j := strings.LastIndexByte(origin, ':')
+	if i < 0 || j <= i || strings.Contains(origin, "*") || isRegexpOrigin(origin) {
+		return portRangeOrigin{}, false, nil
+	}
+	ports := origin[j+1:]
+	if !strings.Contains(ports, "-") || strings.Contains(ports, "]") {
+		return portRangeOrigin{}, false, nil
+	}
+	bad := errors.New("bad origin " + origin + ": port range must be of the form min-max")
+	lo, hi, _ := strings.Cut(ports, "-")
+	min, err := strconv.Atoi(lo)
+	if err != nil || lo[0] == '+' {
+		return portRangeOrigin{}, false, bad
+	}
+	max, err := strconv.Atoi(hi)
+	if err != nil || hi[0] == '+' || min < 1 || min > max || max > 65535 {
+		return portRangeOrigin{}, false, bad
+	}
+	return portRangeOrigin{
+		origin: normalizeIPv6Host(asciiLower(origin[:j])),
+		min:    min,
+		max:    max,
+	}, true, nil
+}
+
+// match reports whether origin, stripped of its default port, is in the range.
+func (r portRangeOrigin) match(origin string) bool {
+	var ports string
+	if origin == r.origin {
+		ports = defaultPorts[origin[:strings.Index(origin, "://")]]
+	} else if i := strings.LastIndexByte(origin, ':'); i >= 0 && origin[:i] == r.origin {
+		ports = origin[i+1:]
+	}
+	port, err := strconv.Atoi(ports)
+	return err == nil && ports[0] != '+' && port >= r.min && port <= r.max
+}
+
+// isLocalhostOrigin reports whether origin is an http or https origin
+// whose host is localhost, a loopback address or the unspecified address.
+func isLocalhostOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	if u.Path != "" || u.User != nil || u.RawQuery != "" {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.Equal(net.IPv4zero))
+}
+
+// isWithinApexes reports whether host is a subdomain of one of apexes, or
+// one of apexes itself when includeApex is set.
+func isWithinApexes(host string, apexes []string, includeApex bool) bool {
+	host = asciiLower(host)
+	for _, apex := range apexes {
+		apex = asciiLower(strings.TrimSpace(apex))
+		if strings.HasSuffix(host, "."+apex) || includeApex && host == apex {
+			return true
+		}
+	}
+	return false
+}
+
+// originMatchesSNI reports whether the host of origin is the TLS server name
+// requested by the client of req.
+func originMatchesSNI(req *http.Request, origin string) bool {
+	if req.TLS == nil || req.TLS.ServerName == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Hostname(), req.TLS.ServerName)
+}
+
+// anyOriginFunc returns a func allowing an origin when fn or one of fns
+// allows it, evaluated in order, or nil when there is no func.
+func anyOriginFunc(fn func(string) bool, fns []func(string) bool) func(string) bool {
+	if fn != nil {
+		fns = append([]func(string) bool{fn}, fns...)
+	}
+	switch len(fns) {
+	case 0:
+		return nil
+	case 1:
+		return fns[0]
+	}
+	return func(origin string) bool {
+		for _, fn := range fns {
+			if fn(origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// originMatchesScheme reports whether the scheme of origin is the scheme req
+// was received with.
+func originMatchesScheme(req *http.Request, origin string) bool {
+	scheme := "http://"
+	if req.TLS != nil {
+		scheme = "https://"
+	}
+	return len(origin) >= len(scheme) && strings.EqualFold(origin[:len(scheme)], scheme)
+}
+
+// stripWWWPrefix removes a leading "www." from the host of origin.
+func stripWWWPrefix(origin string) string {
+	i := strings.Index(origin, "://")
+	if i < 0 {
+		return strings.TrimPrefix(origin, "www.")
+	}
+	return origin[:i+3] + strings.TrimPrefix(origin[i+3:], "www.")
+}
+
+// isSameOrigin reports whether the host and port of origin match host,
+// ignoring case and the default port of the origin scheme.
+func isSameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || host == "" {
+		return false
+	}
+	target, err := url.Parse(u.Scheme + "://" + host)
+	if err != nil {
+		return false
+	}
+	return originFromURL(u) == originFromURL(target)
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+}
+
+// stripDefaultPort removes the port from origin when it is the default one
+// for its scheme, e.g. https://example.com:443 becomes https://example.com
+func stripDefaultPort(origin string) string {
+	i := strings.Index(origin, "://")
+	if i < 0 {
+		return origin
+	}
+	port, ok := defaultPorts[strings.ToLower(origin[:i])]
+	if !ok || !strings.HasSuffix(origin, ":"+port) {
+		return origin
+	}
+	host := origin[i+3 : len(origin)-len(port)-1]
+	if host == "" || strings.ContainsAny(host, "/?#") {
+		return origin
+	}
+	return origin[:len(origin)-len(port)-1]
+}
+
+// stripPort removes the port from origin, e.g. https://example.com:8443
+// becomes https://example.com
+func stripPort(origin string) string {
+	i := strings.LastIndexByte(origin, ':')
+	if i < 0 || i == len(origin)-1 || strings.IndexByte(origin, ':') == i {
+		return origin
+	}
+	for _, r := range origin[i+1:] {
+		if r < '0' || r > '9' {
+			return origin
+		}
+	}
+	return origin[:i]
+}
+
+// normalizeIPv6Host rewrites a bracketed IPv6 host of origin in its
+// canonical form, e.g. http://[2001:DB8:0::1] becomes http://[2001:db8::1]
+func normalizeIPv6Host(origin string) string {
+	i := strings.Index(origin, "://[")
+	if i < 0 {
+		return origin
+	}
+	start := i + len("://[")
+	end := strings.IndexByte(origin[start:], ']')
+	if end < 0 {
+		return origin
+	}
+	ip := net.ParseIP(origin[start : start+end])
+	if ip == nil || ip.To4() != nil {
+		return origin
+	}
+	return origin[:start] + ip.String() + origin[start+end:]
+}
+
+// originFromURL returns the serialized origin of u, dropping the port
+// when it is the default one for the scheme.
+func originFromURL(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	port := u.Port()
+	if port == "" || defaultPorts[scheme] == port {
+		return scheme + "://" + host
+	}
+	return scheme + "://" + host + ":" + port
+}
+
+// isToken reports whether s is a token as defined by RFC 7230 section 3.2.6.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r >= 0x7f || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalOrigin returns origin with a lowercased scheme and host and
+// without the default port, or origin unchanged when it can not be parsed.
+func canonicalOrigin(origin string) string {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return origin
+	}
+	return originFromURL(u)
+}
+
+// flattenHeaders turns h into a slice of entries sorted by key,
+// so that writing them does not require iterating over a map.
+func flattenHeaders(h http.Header) []headerEntry {
+	entries := make([]headerEntry, 0, len(h))
+	for key, values := range h {
+		entries = append(entries, headerEntry{key: key, values: values})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries
+}
+
+// parseHeaderList splits a comma separated list of header names
+// and returns them canonicalized, without empty or duplicate entries.
+func parseHeaderList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	var names []string
+	for _, name := range normalize(strings.Split(value, ",")) {
+		if name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names
+}
+
+// countHeaderList returns the number of non empty names in a comma separated
+// list of header names, without allocating.
+func countHeaderList(value string) int {
+	n := 0
+	for value != "" {
+		name := value
+		if i := strings.IndexByte(value, ','); i >= 0 {
+			name, value = value[:i], value[i+1:]
+		} else {
+			value = ""
+		}
+		if strings.TrimSpace(name) != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// headerNames returns the distinct header names of values, canonicalized
+// unless preserveCase is set.
+func headerNames(values []string, preserveCase bool) []string {
+	if !preserveCase {
+		return convert(normalize(values), http.CanonicalHeaderKey)
+	}
+	distinctMap := make(map[string]bool, len(values))
+	names := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		key := asciiLower(value)
+		if !distinctMap[key] {
+			names = append(names, value)
+			distinctMap[key] = true
+		}
+	}
+	return names
+}
+
+// addVary adds value to the Vary header without modifying the slice shared
+// with the precomputed headers.
+func addVary(header http.Header, value string) {
+	values := header.Values("Vary")
+	header["Vary"] = append(values[:len(values):len(values)], value)
+}
+
+// normalize trims, lowercases and deduplicates values. Only ASCII letters are
+// lowercased, header names and origin schemes and hosts being ASCII, so that
+// Unicode case mappings such as the Kelvin sign to "k" never make two
+// different values equal.
 func normalize(values []string) []string {
 	if values == nil {
 		return nil
@@ -72,7 +497,7 @@ func normalize(values []string) []string {
 	normalized := make([]string, 0, len(values))
 	for _, value := range values {
 		value = strings.TrimSpace(value)
-		value = strings.ToLower(value)
+		value = asciiLower(value)
 		if _, seen := distinctMap[value]; !seen {
 			normalized = append(normalized, value)
 			distinctMap[value] = true
@@ -81,6 +506,23 @@ func normalize(values []string) []string {
 	return normalized
 }
 
+// asciiLower returns s with the ASCII letters A-Z lowercased and any other
+// byte unchanged.
+func asciiLower(s string) string {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'Z' {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				if 'A' <= b[j] && b[j] <= 'Z' {
+					b[j] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return s
+}
+
 func convert(s []string, c converter) []string {
 	var out []string
 	for _, i := range s {